@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	srv "github.com/jaam8/mattermost_bot/internal/service"
+	"go.uber.org/zap"
+	"io"
+	"os"
+)
+
+const commandsUsage = `usage: mattermost_bot [command]
+
+without a command the bot is started
+
+commands:
+  check [-repair]  find orphan votes and vote counts which differ from the votes space,
+                   with -repair delete orphans and recompute counts
+  backup           write polls, votes, reminders and audit log to stdout as JSON lines
+  restore [-dry-run]
+                   read a backup from stdin and write it to the storage, tuples already
+                   present are replaced, with -dry-run the backup is only validated
+`
+
+// runCommand runs the maintenance command and returns the exit code
+func runCommand(args []string, integrity *srv.Integrity, backups *srv.Backups, log *zap.Logger) int {
+	switch args[0] {
+	case "check":
+		fs := flag.NewFlagSet("check", flag.ContinueOnError)
+		repair := fs.Bool("repair", false, "delete orphan votes and recompute vote counts")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		report, err := integrity.Check(*repair)
+		if err != nil {
+			log.Error("integrity check failed", zap.Error(err))
+			return 1
+		}
+		printReport(os.Stdout, report)
+		if !report.OK() && !report.Repaired {
+			return 1
+		}
+		return 0
+	case "backup":
+		counts, err := backups.Backup(os.Stdout)
+		if err != nil {
+			log.Error("backup failed", zap.Error(err))
+			return 1
+		}
+		log.Info("backup finished", zap.Any("tuples", counts))
+		return 0
+	case "restore":
+		fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "only validate the backup")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		counts, err := backups.Restore(os.Stdin, *dryRun)
+		if err != nil {
+			log.Error("restore failed", zap.Error(err), zap.Any("restored", counts))
+			return 1
+		}
+		log.Info("restore finished", zap.Any("tuples", counts), zap.Bool("dry_run", *dryRun))
+		return 0
+	default:
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return 2
+	}
+}
+
+// checkIntegrity runs the startup integrity check in the configured mode, problems are only logged
+func checkIntegrity(integrity *srv.Integrity, mode string, log *zap.Logger) {
+	if mode == srv.IntegrityOff {
+		return
+	}
+	report, err := integrity.Check(mode == srv.IntegrityRepair)
+	if err != nil {
+		log.Error("integrity check failed", zap.Error(err))
+		return
+	}
+	if report.OK() {
+		log.Info("integrity check passed",
+			zap.Int("polls", report.Polls),
+			zap.Int("votes", report.Votes))
+		return
+	}
+	log.Warn("integrity check found problems",
+		zap.Int("orphan_votes", len(report.Orphans)),
+		zap.Int("count_mismatches", len(report.Mismatches)),
+		zap.Bool("repaired", report.Repaired),
+		zap.Any("orphans", report.Orphans),
+		zap.Any("mismatches", report.Mismatches))
+}
+
+func printReport(w io.Writer, report *models.IntegrityReport) {
+	fmt.Fprintf(w, "checked %d polls and %d votes\n", report.Polls, report.Votes)
+	for _, vote := range report.Orphans {
+		fmt.Fprintf(w, "orphan vote: poll %s, user %s, choice %s\n", vote.PollID, vote.UserID, vote.ChoiceID)
+	}
+	for _, mismatch := range report.Mismatches {
+		fmt.Fprintf(w, "count mismatch: poll %s, choice %s, stored %d, actual %d\n",
+			mismatch.PollID, mismatch.ChoiceID, mismatch.Stored, mismatch.Actual)
+	}
+	switch {
+	case report.OK():
+		fmt.Fprintln(w, "no problems found")
+	case report.Repaired:
+		fmt.Fprintf(w, "repaired %d orphan votes and %d counts\n", len(report.Orphans), len(report.Mismatches))
+	default:
+		fmt.Fprintln(w, "run check -repair to fix")
+	}
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/jaam8/mattermost_bot/internal/api"
+	"github.com/jaam8/mattermost_bot/internal/api/rest"
 	"github.com/jaam8/mattermost_bot/internal/config"
+	"github.com/jaam8/mattermost_bot/internal/metrics"
 	"github.com/jaam8/mattermost_bot/internal/repository"
 	srv "github.com/jaam8/mattermost_bot/internal/service"
 	"github.com/jaam8/mattermost_bot/pkg/logger"
-	"github.com/jaam8/mattermost_bot/pkg/tarantool"
+	"github.com/jaam8/mattermost_bot/pkg/workerpool"
 	"github.com/mattermost/mattermost-server/v6/model"
-	got "github.com/tarantool/go-tarantool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	logg "log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -28,23 +32,20 @@ func main() {
 	if err != nil {
 		logg.Fatalf("failed to initalize logger: %s", err)
 	}
-	conn, err := tarantool.New(cfg.Tarantool)
+	store, err := openStorage(ctx, cfg, log)
 	if err != nil {
-		logg.Fatalf("failed to connect to Tarantool: %s", err)
+		logg.Fatalf("failed to open storage: %s", err)
 	}
-
-	client := model.NewAPIv4Client(cfg.MmURL)
-	webSocketClient, err := model.NewWebSocketClient4(cfg.MmWsURL, cfg.BotToken)
-	if err != nil {
-		logg.Fatalf("failed to connect to webSocket: %v", err)
+	integrity := srv.NewIntegrity(store.polls, log)
+	if len(os.Args) > 1 {
+		backups := srv.NewBackups(store.backups, log)
+		code := runCommand(os.Args[1:], integrity, backups, log)
+		store.close()
+		os.Exit(code)
 	}
 
-	repo := repository.New(conn, log)
-	service := srv.New(repo, log)
-	handler := api.New(service, log, client)
-
+	client := model.NewAPIv4Client(cfg.MmURL)
 	client.SetToken(cfg.BotToken)
-	webSocketClient.Listen()
 	var botID string
 	if user, _, err := client.GetUser("me", ""); err != nil {
 		logg.Fatalf("failed to get user: %s", err)
@@ -53,25 +54,109 @@ func main() {
 		botID = user.Id
 	}
 
+	webhooks := srv.NewWebhooks(store.deliveries, log, cfg.Webhooks)
+	service := srv.New(store.polls, store.reminders, store.audit, webhooks, log, cfg.Service)
+	var buckets srv.BucketStore = srv.NewMemoryBuckets()
+	if cfg.RateLimit.Store == "tarantool" {
+		if store.tarantool == nil {
+			logg.Fatalf("RATE_LIMIT_STORE=tarantool requires STORAGE=tarantool")
+		}
+		buckets = repository.NewRateLimitRepository(store.tarantool, log)
+	}
+	limiter, err := srv.NewRateLimiter(buckets, cfg.RateLimit)
+	if err != nil {
+		logg.Fatalf("failed to configure rate limits: %s", err)
+	}
+	handler := api.New(service, limiter, log, client, botID, cfg.Bot)
+
+	metrics.RegisterActivePolls(service.CountActivePolls)
+	checkIntegrity(integrity, cfg.Integrity.Mode, log)
+
+	listener := api.NewListener(client, cfg.MmWsURL, cfg.BotToken, botID, log, cfg.Websocket)
+	server := rest.New(cfg.RestPort, service, handler, log, cfg.API)
+	server.Handle("GET /metrics", promhttp.Handler())
+	server.Handle("POST "+api.DialogOpenPath, http.HandlerFunc(handler.OpenDialog))
+	server.Handle("POST "+api.DialogSubmitPath, http.HandlerFunc(handler.SubmitDialog))
+	server.AddCheck(cfg.Storage, store.ping)
+	server.AddCheck("websocket", func(ctx context.Context) error {
+		if !listener.Connected() {
+			return errors.New("websocket connection is closed")
+		}
+		return nil
+	})
+	server.AddCheck("mattermost", func(ctx context.Context) error {
+		_, _, err := client.GetUser("me", "")
+		return err
+	})
+
+	go listener.Run(ctx)
+	go handler.RunScheduler(ctx, cfg.SchedulerInterval)
+	go webhooks.Run(ctx)
+	go service.RunRetention(ctx)
 	go func() {
-		for event := range webSocketClient.EventChannel {
-			if event.EventType() == model.WebsocketEventPosted {
-				log.Debug("new message", zap.String("event", event.EventType()))
-				api.HandleMessage(handler, event, botID)
-			}
+		if err := server.Run(ctx); err != nil {
+			log.Error("http server stopped", zap.Error(err))
 		}
 	}()
 
+	pool := workerpool.New(cfg.Workers)
+	// dispatchCtx stops the dispatcher when the shutdown timeout passes while it waits for a worker
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		dispatch(dispatchCtx, listener.Events(), pool, handler, botID, log)
+	}()
+
 	select {
 	case <-ctx.Done():
-		resp, err := conn.Select("polls", "primary", 0, 10, got.IterEq, []interface{}{})
-		if err != nil {
-			logg.Fatalf("failed to select: %s", err)
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		select {
+		case <-dispatched:
+		case <-drainCtx.Done():
+		}
+		stopDispatch()
+		<-dispatched
+		if err := pool.Close(drainCtx); err != nil {
+			log.Warn("not all events were processed before shutdown", zap.Error(err))
 		}
-		logg.Println(resp)
-		conn.CloseGraceful()
-		webSocketClient.Close()
+		cancel()
+		store.close()
 		stop()
 		logg.Println("server graceful stopped")
 	}
 }
+
+// dispatch submits events to the pool until the events channel is closed or ctx is done
+func dispatch(ctx context.Context, events <-chan *model.WebSocketEvent, pool *workerpool.Pool,
+	handler *api.PollHandler, botID string, log *zap.Logger) {
+	for {
+		var event *model.WebSocketEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			event = e
+		}
+		var task func()
+		switch event.EventType() {
+		case model.WebsocketEventPosted:
+			log.Debug("new message", zap.String("event", event.EventType()))
+			task = func() { api.HandleMessage(handler, event, botID) }
+		case model.WebsocketEventReactionAdded, model.WebsocketEventReactionRemoved:
+			log.Debug("new reaction", zap.String("event", event.EventType()))
+			task = func() { handler.HandleReaction(event) }
+		default:
+			continue
+		}
+		if err := pool.Submit(ctx, handler.EventKey(event), task); err != nil {
+			log.Warn("event was not processed before shutdown",
+				zap.String("event", event.EventType()),
+				zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/config"
+	"github.com/jaam8/mattermost_bot/internal/repository"
+	srv "github.com/jaam8/mattermost_bot/internal/service"
+	"github.com/jaam8/mattermost_bot/pkg/postgres"
+	"github.com/jaam8/mattermost_bot/pkg/sqlite"
+	"github.com/jaam8/mattermost_bot/pkg/tarantool"
+	got "github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+)
+
+const (
+	storageTarantool = "tarantool"
+	storagePostgres  = "postgres"
+	storageSQLite    = "sqlite"
+)
+
+// storage holds the stores of the backend selected by STORAGE
+type storage struct {
+	polls      srv.PollStore
+	reminders  srv.ReminderStore
+	audit      srv.AuditStore
+	deliveries srv.DeliveryStore
+	backups    srv.BackupStore
+	// tarantool is only set for the Tarantool backend, rate limits are kept there
+	tarantool got.Connector
+	ping      func(ctx context.Context) error
+	close     func()
+}
+
+func openStorage(ctx context.Context, cfg *config.Config, log *zap.Logger) (*storage, error) {
+	switch cfg.Storage {
+	case storageTarantool:
+		conn, err := tarantool.New(ctx, cfg.Tarantool, log)
+		if err != nil {
+			return nil, err
+		}
+		return &storage{
+			polls:      repository.New(conn, log),
+			reminders:  repository.NewReminderRepository(conn, log),
+			audit:      repository.NewAuditRepository(conn, log),
+			deliveries: repository.NewWebhookRepository(conn, log),
+			backups:    repository.NewBackupRepository(conn, log),
+			tarantool:  conn,
+			ping: func(ctx context.Context) error {
+				_, err := conn.Ping()
+				return err
+			},
+			close: func() { tarantool.Close(conn) },
+		}, nil
+	case storagePostgres:
+		pool, err := postgres.New(ctx, cfg.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		if err = repository.MigratePostgres(ctx, pool, log); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return &storage{
+			polls:      repository.NewPostgresPollRepository(pool, log),
+			reminders:  repository.NewPostgresReminderRepository(pool, log),
+			audit:      repository.NewPostgresAuditRepository(pool, log),
+			deliveries: repository.NewPostgresWebhookRepository(pool, log),
+			backups:    repository.NewPostgresBackupRepository(pool, log),
+			ping:       pool.Ping,
+			close:      pool.Close,
+		}, nil
+	case storageSQLite:
+		db, err := sqlite.New(cfg.SQLite)
+		if err != nil {
+			return nil, err
+		}
+		if err = repository.MigrateSQLite(ctx, db, log); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &storage{
+			polls:      repository.NewSQLitePollRepository(db, log),
+			reminders:  repository.NewSQLiteReminderRepository(db, log),
+			audit:      repository.NewSQLiteAuditRepository(db, log),
+			deliveries: repository.NewSQLiteWebhookRepository(db, log),
+			backups:    repository.NewSQLiteBackupRepository(db, log),
+			ping:       db.PingContext,
+			close:      func() { db.Close() },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q, should be %s, %s or %s",
+			cfg.Storage, storageTarantool, storagePostgres, storageSQLite)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// auditEntriesLimit is how many latest entries /poll audit shows
+const auditEntriesLimit = 50
+
+// Audit returns the audit log of the poll as a message, available to the poll owner and system admins
+func (h *PollHandler) Audit(pollID, userID string) (string, error) {
+	entries, err := h.s.Audit(pollID, userID, h.isAdmin(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			h.l.Warn("poll not found", zap.String("poll_id", pollID))
+			return "", err
+		case errors.Is(err, models.ErrUserNotOwner):
+			h.l.Warn("user is not owner of poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			return "", err
+		default:
+			h.l.Error("failed to get audit log",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+			return "", fmt.Errorf("handler: failed to get audit log: %w", err)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Audit log** of the poll `%s`:\n", pollID)
+	if len(entries) > auditEntriesLimit {
+		fmt.Fprintf(&b, "_%d earlier entries are not shown_\n", len(entries)-auditEntriesLimit)
+		entries = entries[len(entries)-auditEntriesLimit:]
+	}
+	usernames := h.usernames(entries)
+	for _, entry := range entries {
+		actor := "system"
+		if entry.ActorID != "" {
+			actor = "@" + usernames[entry.ActorID]
+		} else if entry.Action == models.AuditVote || entry.Action == models.AuditUnvote {
+			actor = "anonymous"
+		}
+		fmt.Fprintf(&b, "- %s %s **%s** %s\n",
+			entry.CreatedAt.UTC().Format(time.DateTime), actor, entry.Action, entry.Outcome)
+	}
+	return b.String(), nil
+}
+
+// usernames returns usernames of the entry actors, ids are used for users which are not found
+func (h *PollHandler) usernames(entries []*models.AuditEntry) map[string]string {
+	usernames := map[string]string{}
+	var ids []string
+	for _, entry := range entries {
+		if _, ok := usernames[entry.ActorID]; entry.ActorID != "" && !ok {
+			usernames[entry.ActorID] = entry.ActorID
+			ids = append(ids, entry.ActorID)
+		}
+	}
+	if len(ids) == 0 {
+		return usernames
+	}
+	users, _, err := h.client.GetUsersByIds(ids)
+	if err != nil {
+		h.l.Warn("failed to get usernames for audit log", zap.Error(err))
+		return usernames
+	}
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+	return usernames
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/pkg/chart"
+	"go.uber.org/zap"
+)
+
+// uploadChart renders poll results as png bar chart and uploads it to the channel, returns file id
+func (h *PollHandler) uploadChart(res *results, channelID string) (string, error) {
+	bars := make([]chart.Bar, 0, len(res.rows))
+	for _, row := range res.rows {
+		bars = append(bars, chart.Bar{
+			Label: fmt.Sprintf("[%d] %s", row.option.ID, row.option.Text),
+			Value: row.votes,
+		})
+	}
+	png, err := chart.HorizontalBars(res.poll.Question, bars)
+	if err != nil {
+		return "", fmt.Errorf("handler: failed to render chart: %w", err)
+	}
+	resp, _, err := h.client.UploadFile(png, channelID, fmt.Sprintf("poll_%s.png", res.poll.ID))
+	if err != nil {
+		return "", fmt.Errorf("handler: failed to upload chart: %w", err)
+	}
+	if len(resp.FileInfos) == 0 {
+		return "", fmt.Errorf("handler: upload response has no files")
+	}
+	h.l.Debug("uploaded results chart",
+		zap.String("poll_id", res.poll.ID),
+		zap.String("file_id", resp.FileInfos[0].Id))
+	return resp.FileInfos[0].Id, nil
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DialogOpenPath   = "/api/v1/dialog/open"
+	DialogSubmitPath = "/api/v1/dialog/submit"
+
+	newPollCallback = "poll_new"
+)
+
+// dialog field names, they are also the keys of field errors
+const (
+	fieldQuestion   = "question"
+	fieldOptions    = "options"
+	fieldType       = "type"
+	fieldAnonymous  = "anonymous"
+	fieldDeadline   = "deadline"
+	fieldMaxChoices = "max_choices"
+	fieldReactions  = "reactions"
+)
+
+// SendNewPollButton answers /poll new with a button that opens the poll dialog,
+// dialogs need trigger id which only comes with interactive actions
+func (h *PollHandler) SendNewPollButton(userID, channelID, rootID string) error {
+	if h.cfg.BotURL == "" {
+		return errDialogsDisabled
+	}
+	post := &model.Post{ChannelId: channelID, RootId: rootID}
+	post.AddProp("attachments", []*model.SlackAttachment{{
+		Text: "create a poll in this channel",
+		Actions: []*model.PostAction{{
+			Id:   "newpoll",
+			Type: model.PostActionTypeButton,
+			Name: "Create poll",
+			Integration: &model.PostActionIntegration{
+				URL: h.cfg.BotURL + DialogOpenPath,
+				Context: map[string]interface{}{
+					"signature": h.sign(userID, channelID, rootID),
+					"root_id":   rootID,
+				},
+			},
+		}},
+	}})
+	h.sendEphemeral(&model.PostEphemeral{UserID: userID, Post: post})
+	return nil
+}
+
+// OpenDialog handles the button click and opens the poll dialog
+func (h *PollHandler) OpenDialog(w http.ResponseWriter, r *http.Request) {
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	signature, _ := req.Context["signature"].(string)
+	rootID, _ := req.Context["root_id"].(string)
+	if !h.verify(signature, req.UserId, req.ChannelId, rootID) {
+		h.l.Warn("dialog request with invalid signature",
+			zap.String("user_id", req.UserId),
+			zap.String("channel_id", req.ChannelId))
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	dialog := model.OpenDialogRequest{
+		TriggerId: req.TriggerId,
+		URL:       h.cfg.BotURL + DialogSubmitPath,
+		Dialog: model.Dialog{
+			CallbackId:  newPollCallback,
+			Title:       "New poll",
+			SubmitLabel: "Create",
+			// the thread of /poll new goes through the dialog, so the poll is posted there
+			State: signature + ":" + rootID,
+			Elements: []model.DialogElement{
+				{DisplayName: "Question", Name: fieldQuestion, Type: "text", MaxLength: 150},
+				{DisplayName: "Options", Name: fieldOptions, Type: "textarea",
+					HelpText: "one option per line, at least 2"},
+				{DisplayName: "Type", Name: fieldType, Type: "select", Default: "single",
+					Options: []*model.PostActionOptions{
+						{Text: "Single choice", Value: "single"},
+						{Text: "Multiple choice", Value: "multiple"},
+					}},
+				{DisplayName: "Max choices", Name: fieldMaxChoices, Type: "text", SubType: "number", Optional: true,
+					HelpText: "for multiple choice polls, all options by default"},
+				{DisplayName: "Deadline", Name: fieldDeadline, Type: "text", Optional: true,
+					Placeholder: "24h or 2006-01-02T15:04:05Z"},
+				{DisplayName: "Anonymous", Name: fieldAnonymous, Type: "bool", Optional: true,
+					Placeholder: "do not export ballots"},
+				{DisplayName: "Reactions", Name: fieldReactions, Type: "bool", Optional: true,
+					Placeholder: "vote with emoji reactions, up to 10 options"},
+			},
+		},
+	}
+	if _, err := h.client.OpenInteractiveDialog(dialog); err != nil {
+		h.l.Error("failed to open dialog",
+			zap.String("user_id", req.UserId),
+			zap.Error(err))
+		writeDialogJSON(w, model.PostActionIntegrationResponse{EphemeralText: "somthing went wrong"})
+		return
+	}
+	writeDialogJSON(w, model.PostActionIntegrationResponse{})
+}
+
+// SubmitDialog validates the dialog with the rules of CreatePoll and creates the poll
+func (h *PollHandler) SubmitDialog(w http.ResponseWriter, r *http.Request) {
+	var req model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	if req.Cancelled || req.CallbackId != newPollCallback {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	signature, rootID, _ := strings.Cut(req.State, ":")
+	if !h.verify(signature, req.UserId, req.ChannelId, rootID) {
+		h.l.Warn("dialog submission with invalid signature",
+			zap.String("user_id", req.UserId),
+			zap.String("channel_id", req.ChannelId))
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	question, options, settings, fieldErrors := parseDialog(req.Submission)
+	if len(fieldErrors) > 0 {
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+	// the dialog creates a poll like /poll create, so it takes from the same limit
+	if !h.allow("create", req.UserId, req.ChannelId) {
+		writeDialogJSON(w, model.SubmitDialogResponse{Error: models.ErrRateLimited.Error()})
+		return
+	}
+	err := h.CreatePoll(question, req.UserId, req.ChannelId, rootID, options, settings)
+	switch {
+	case err == nil:
+		writeDialogJSON(w, model.SubmitDialogResponse{})
+	case errors.Is(err, models.ErrQuestionIsEmpty):
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: map[string]string{fieldQuestion: err.Error()}})
+	case errors.Is(err, models.ErrNotEnoughOptions), errors.Is(err, models.ErrOptionIsEmpty),
+		errors.Is(err, models.ErrInvalidReactions):
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: map[string]string{fieldOptions: err.Error()}})
+	case errors.Is(err, models.ErrInvalidDeadline):
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: map[string]string{fieldDeadline: err.Error()}})
+	case errors.Is(err, models.ErrInvalidMaxChoices):
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: map[string]string{fieldMaxChoices: err.Error()}})
+	case errors.Is(err, models.ErrAnonymousReactions):
+		writeDialogJSON(w, model.SubmitDialogResponse{Errors: map[string]string{fieldReactions: err.Error()}})
+	default:
+		writeDialogJSON(w, model.SubmitDialogResponse{Error: "somthing went wrong"})
+	}
+}
+
+// parseDialog reads the submitted fields, values which can not be parsed are returned as field errors
+func parseDialog(submission map[string]interface{}) (string, []string, models.Settings, map[string]string) {
+	var settings models.Settings
+	fieldErrors := map[string]string{}
+
+	question, _ := submission[fieldQuestion].(string)
+	rawOptions, _ := submission[fieldOptions].(string)
+	var options []string
+	for _, line := range strings.Split(rawOptions, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			options = append(options, line)
+		}
+	}
+	settings.Anonymous, _ = submission[fieldAnonymous].(bool)
+	settings.Reactions, _ = submission[fieldReactions].(bool)
+	if settings.Anonymous && settings.Reactions {
+		fieldErrors[fieldReactions] = models.ErrAnonymousReactions.Error()
+	}
+
+	if value, _ := submission[fieldDeadline].(string); strings.TrimSpace(value) != "" {
+		deadline, err := parseDeadline(strings.TrimSpace(value))
+		if err != nil {
+			fieldErrors[fieldDeadline] = err.Error()
+		} else {
+			settings.Deadline = &deadline
+		}
+	}
+
+	maxChoices, ok := dialogNumber(submission[fieldMaxChoices])
+	if !ok {
+		fieldErrors[fieldMaxChoices] = models.ErrInvalidMaxChoices.Error()
+	}
+	switch pollType, _ := submission[fieldType].(string); pollType {
+	case "multiple":
+		if maxChoices == 0 {
+			maxChoices = len(options)
+		}
+		settings.MaxChoices = maxChoices
+	default:
+		if maxChoices > 1 {
+			fieldErrors[fieldMaxChoices] = "max choices is only for multiple choice polls"
+		}
+	}
+	return strings.TrimSpace(question), options, settings, fieldErrors
+}
+
+// dialogNumber reads optional number field, the webapp submits it as a number or a string
+func dialogNumber(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case nil:
+		return 0, true
+	case float64:
+		return int(v), v == float64(int(v))
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return 0, true
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// sign returns signature which binds the dialog to the user, the channel and the thread it was requested in
+func (h *PollHandler) sign(userID, channelID, rootID string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(userID + ":" + channelID + ":" + rootID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *PollHandler) verify(signature, userID, channelID, rootID string) bool {
+	return hmac.Equal([]byte(signature), []byte(h.sign(userID, channelID, rootID)))
+}
+
+func writeDialogJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// minePollsLimit is how many polls /poll mine lists, the message would be cut by Mattermost otherwise
+const minePollsLimit = 50
+
+var errNoPollChannel = errors.New("the poll is not posted to any channel")
+
+// MyPolls returns the list of polls created by the user in all channels with links to the poll posts
+func (h *PollHandler) MyPolls(userID string) (string, error) {
+	polls, err := h.s.ListPolls("", userID)
+	if err != nil {
+		h.l.Error("failed to list user polls",
+			zap.String("user_id", userID),
+			zap.Error(err))
+		return "", fmt.Errorf("handler: failed to list polls: %w", err)
+	}
+	if len(polls) == 0 {
+		return "you have no polls yet, create one with `/poll create` or `/poll new`", nil
+	}
+	channels := map[string]string{}
+	var b strings.Builder
+	b.WriteString("**Your polls**:\n")
+	for i, poll := range polls {
+		if i == minePollsLimit {
+			fmt.Fprintf(&b, "_and %d more_\n", len(polls)-minePollsLimit)
+			break
+		}
+		status := "active"
+		if !poll.IsActive {
+			status = "ended"
+		}
+		if poll.Pinned {
+			status += ", pinned"
+		}
+		total := 0
+		for _, votes := range poll.Votes {
+			total += votes
+		}
+		fmt.Fprintf(&b, "- `%s` %s — %s, %d votes", poll.ID, poll.Question, status, total)
+		if name := h.channelName(poll.ChannelID, channels); name != "" {
+			fmt.Fprintf(&b, ", ~%s", name)
+		}
+		fmt.Fprintf(&b, " — %s\n", h.pollLink(poll))
+	}
+	return b.String(), nil
+}
+
+// channelName returns the name of the channel for mentions, names are cached in names for one listing
+func (h *PollHandler) channelName(channelID string, names map[string]string) string {
+	if channelID == "" {
+		return ""
+	}
+	if name, ok := names[channelID]; ok {
+		return name
+	}
+	channel, _, err := h.client.GetChannel(channelID, "")
+	if err != nil {
+		h.l.Warn("failed to get channel",
+			zap.String("channel_id", channelID),
+			zap.Error(err))
+		names[channelID] = ""
+		return ""
+	}
+	names[channelID] = channel.Name
+	return channel.Name
+}
+
+func withLink(message, link string) string {
+	if link == "" {
+		return message
+	}
+	return message + ": " + link
+}
+
+// pollLinkByID returns the link to the poll for command responses, empty if the poll is not found
+func (h *PollHandler) pollLinkByID(pollID string) string {
+	poll, err := h.s.GetPollResult(pollID)
+	if err != nil {
+		if !errors.Is(err, models.ErrPollNotFound) {
+			h.l.Warn("failed to get poll for link",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+		}
+		return ""
+	}
+	return h.pollLink(poll)
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnknownFormat = errors.New("unknown export format, use csv or json")
+
+type pollExport struct {
+	ID         string         `json:"id"`
+	Question   string         `json:"question"`
+	CreatorID  string         `json:"creator_id"`
+	ChannelID  string         `json:"channel_id"`
+	IsActive   bool           `json:"is_active"`
+	Anonymous  bool           `json:"anonymous"`
+	TotalVotes int            `json:"total_votes"`
+	Options    []optionExport `json:"options"`
+	Ballots    []ballotExport `json:"ballots,omitempty"`
+	ExportedAt time.Time      `json:"exported_at"`
+}
+
+type optionExport struct {
+	ID    int    `json:"id"`
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+type ballotExport struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	ChoiceID int    `json:"choice_id"`
+	Choice   string `json:"choice"`
+}
+
+// Export sends poll results and ballots as a file to the direct channel with the user
+func (h *PollHandler) Export(pollID, userID, format string) error {
+	h.l.Debug("data for exporting poll",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID),
+		zap.String("format", format))
+	if format != "csv" && format != "json" {
+		return errUnknownFormat
+	}
+	poll, votes, err := h.s.Export(pollID, userID, h.isAdmin(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			h.l.Warn("poll not found", zap.String("poll_id", pollID))
+			return err
+		case errors.Is(err, models.ErrUserNotOwner):
+			h.l.Warn("user is not owner of poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			return err
+		default:
+			h.l.Error("failed to export poll",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+			return fmt.Errorf("handler: failed to export poll: %w", err)
+		}
+	}
+	export, err := h.newExport(poll, votes)
+	if err != nil {
+		h.l.Error("failed to prepare export", zap.Error(err))
+		return fmt.Errorf("handler: failed to prepare export: %w", err)
+	}
+	var data []byte
+	if format == "json" {
+		data, err = json.MarshalIndent(export, "", "  ")
+	} else {
+		data, err = export.csv()
+	}
+	if err != nil {
+		h.l.Error("failed to encode export", zap.Error(err))
+		return fmt.Errorf("handler: failed to encode export: %w", err)
+	}
+
+	channel, _, err := h.client.CreateDirectChannel(h.botID, userID)
+	if err != nil {
+		h.l.Error("failed to create direct channel", zap.Error(err))
+		return fmt.Errorf("handler: failed to create direct channel: %w", err)
+	}
+	upload, _, err := h.client.UploadFile(data, channel.Id, fmt.Sprintf("poll_%s.%s", poll.ID, format))
+	if err != nil || len(upload.FileInfos) == 0 {
+		h.l.Error("failed to upload export", zap.Error(err))
+		return fmt.Errorf("handler: failed to upload export: %w", err)
+	}
+	_, err = h.sendPost(&model.Post{
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("export of the poll **%s**", poll.Question),
+		FileIds:   model.StringArray{upload.FileInfos[0].Id},
+	})
+	if err != nil {
+		h.l.Error("failed to send export", zap.Error(err))
+		return fmt.Errorf("handler: failed to send export: %w", err)
+	}
+	h.l.Info("successfully exported poll",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID),
+		zap.String("format", format))
+	return nil
+}
+
+func (h *PollHandler) newExport(poll *models.Poll, votes []models.Vote) (*pollExport, error) {
+	export := &pollExport{
+		ID:         poll.ID,
+		Question:   poll.Question,
+		CreatorID:  poll.CreatorID,
+		ChannelID:  poll.ChannelID,
+		IsActive:   poll.IsActive,
+		Anonymous:  poll.Settings.Anonymous,
+		ExportedAt: time.Now().UTC(),
+	}
+	texts := make(map[string]string, len(poll.Options))
+	for _, option := range poll.Options {
+		count := poll.Votes[strconv.Itoa(option.ID)]
+		export.TotalVotes += count
+		export.Options = append(export.Options, optionExport{ID: option.ID, Text: option.Text, Votes: count})
+		texts[strconv.Itoa(option.ID)] = option.Text
+	}
+	if len(votes) == 0 {
+		return export, nil
+	}
+	// voters anonymized by the retention policy are not Mattermost users
+	usernames := make(map[string]string)
+	var ids []string
+	for _, vote := range votes {
+		if _, ok := usernames[vote.UserID]; ok {
+			continue
+		}
+		usernames[vote.UserID] = ""
+		if n, ok := strings.CutPrefix(vote.UserID, models.AnonymousVoter); ok {
+			usernames[vote.UserID] = "Anonymous " + n
+			continue
+		}
+		ids = append(ids, vote.UserID)
+	}
+	if len(ids) > 0 {
+		users, _, err := h.client.GetUsersByIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			usernames[user.Id] = user.Username
+		}
+	}
+	for _, vote := range votes {
+		choiceID, _ := strconv.Atoi(vote.ChoiceID)
+		export.Ballots = append(export.Ballots, ballotExport{
+			UserID:   vote.UserID,
+			Username: usernames[vote.UserID],
+			ChoiceID: choiceID,
+			Choice:   texts[vote.ChoiceID],
+		})
+	}
+	return export, nil
+}
+
+// csv writes poll summary, options and ballots as separate tables
+func (e *pollExport) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	status := "open"
+	if !e.IsActive {
+		status = "ended"
+	}
+	records := [][]string{
+		{"poll_id", "question", "status", "total_votes"},
+		{e.ID, e.Question, status, strconv.Itoa(e.TotalVotes)},
+		{},
+		{"option_id", "option", "votes"},
+	}
+	for _, option := range e.Options {
+		records = append(records, []string{strconv.Itoa(option.ID), option.Text, strconv.Itoa(option.Votes)})
+	}
+	if !e.Anonymous {
+		records = append(records, []string{}, []string{"user_id", "username", "option_id", "option"})
+		for _, ballot := range e.Ballots {
+			records = append(records, []string{ballot.UserID, ballot.Username,
+				strconv.Itoa(ballot.ChoiceID), ballot.Choice})
+		}
+	}
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isAdmin reports whether the user is a Mattermost system admin
+func (h *PollHandler) isAdmin(userID string) bool {
+	user, _, err := h.client.GetUser(userID, "")
+	if err != nil {
+		h.l.Warn("failed to get user roles",
+			zap.String("user_id", userID),
+			zap.Error(err))
+		return false
+	}
+	return user.IsSystemAdmin()
+}
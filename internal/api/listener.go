@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jaam8/mattermost_bot/internal/metrics"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// seenWindow is how long ids of delivered posts are kept to skip duplicates after resync
+const seenWindow = 5 * time.Minute
+
+type ListenerConfig struct {
+	ReconnectMin time.Duration `yaml:"WS_RECONNECT_MIN" env:"WS_RECONNECT_MIN" env-default:"1s"`
+	ReconnectMax time.Duration `yaml:"WS_RECONNECT_MAX" env:"WS_RECONNECT_MAX" env-default:"1m"`
+}
+
+// Listener keeps the websocket connection to Mattermost alive, reconnects with backoff
+// and replays posts missed while it was disconnected
+type Listener struct {
+	client    *model.Client4
+	url       string
+	token     string
+	botID     string
+	l         *zap.Logger
+	cfg       ListenerConfig
+	events    chan *model.WebSocketEvent
+	connected atomic.Bool
+
+	mu       sync.Mutex
+	lastSeen int64
+	seen     map[string]int64
+}
+
+func NewListener(client *model.Client4, url, token, botID string, l *zap.Logger, cfg ListenerConfig) *Listener {
+	return &Listener{
+		client:   client,
+		url:      url,
+		token:    token,
+		botID:    botID,
+		l:        l,
+		cfg:      cfg,
+		events:   make(chan *model.WebSocketEvent, 100),
+		lastSeen: model.GetMillis(),
+		seen:     make(map[string]int64),
+	}
+}
+
+// Events returns the channel of websocket events, it is closed when Run returns
+func (ls *Listener) Events() <-chan *model.WebSocketEvent {
+	return ls.events
+}
+
+// Connected reports whether the websocket connection is currently open
+func (ls *Listener) Connected() bool {
+	return ls.connected.Load()
+}
+
+// Run connects to the websocket and reconnects until ctx is done
+func (ls *Listener) Run(ctx context.Context) {
+	defer close(ls.events)
+	delay := ls.cfg.ReconnectMin
+	reconnect := false
+	for {
+		ws, err := model.NewWebSocketClient4(ls.url, ls.token)
+		if err != nil {
+			ls.l.Error("failed to connect to websocket",
+				zap.Duration("retry_in", delay),
+				zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, ls.cfg.ReconnectMax)
+			continue
+		}
+		delay = ls.cfg.ReconnectMin
+
+		ws.Listen()
+		ls.connected.Store(true)
+		if reconnect {
+			metrics.WebsocketReconnected()
+			ls.l.Info("websocket reconnected")
+			ls.resync(ctx)
+		}
+		reconnect = true
+		err = ls.serve(ctx, ws)
+		ls.connected.Store(false)
+		if err == nil {
+			return
+		}
+		ls.l.Warn("websocket connection lost", zap.Error(err))
+	}
+}
+
+// serve forwards events until the connection is closed, nil error means ctx is done
+func (ls *Listener) serve(ctx context.Context, ws *model.WebSocketClient) error {
+	for {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+			return nil
+		case <-ws.PingTimeoutChannel:
+			ws.Close()
+			return errors.New("websocket ping timeout")
+		case <-ws.ResponseChannel:
+		case event, ok := <-ws.EventChannel:
+			if !ok {
+				if ws.ListenError != nil {
+					return ws.ListenError
+				}
+				return errors.New("websocket connection closed")
+			}
+			if event.EventType() == model.WebsocketEventPosted && !ls.markPosted(event) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				ws.Close()
+				return nil
+			case ls.events <- event:
+			}
+		}
+	}
+}
+
+// resync delivers posts created in the bot channels since the last seen post, it stops when ctx is done
+func (ls *Listener) resync(ctx context.Context) {
+	ls.mu.Lock()
+	since := ls.lastSeen
+	ls.mu.Unlock()
+
+	channels, err := ls.channels()
+	if err != nil {
+		ls.l.Error("failed to list channels for resync", zap.Error(err))
+		return
+	}
+	replayed := 0
+	defer func() {
+		ls.l.Info("resynced missed posts",
+			zap.Int("channels", len(channels)),
+			zap.Int("posts", replayed))
+	}()
+	for _, channel := range channels {
+		if ctx.Err() != nil {
+			return
+		}
+		list, _, err := ls.client.GetPostsSince(channel.Id, since, false)
+		if err != nil {
+			ls.l.Error("failed to get missed posts",
+				zap.String("channel_id", channel.Id),
+				zap.Error(err))
+			continue
+		}
+		list.SortByCreateAt()
+		posts := list.ToSlice()
+		// ToSlice returns posts newest first
+		for i := len(posts) - 1; i >= 0; i-- {
+			post := posts[i]
+			if post.CreateAt <= since || post.DeleteAt != 0 || post.UserId == ls.botID {
+				continue
+			}
+			if !ls.markSeen(post.Id, post.CreateAt) {
+				continue
+			}
+			data, err := json.Marshal(post)
+			if err != nil {
+				continue
+			}
+			// the same data as in the posted events the server sends
+			event := model.NewWebSocketEvent(model.WebsocketEventPosted, channel.TeamId, post.ChannelId, "", nil)
+			event.Add("post", string(data))
+			event.Add("channel_type", string(channel.Type))
+			event.Add("channel_name", channel.Name)
+			select {
+			case <-ctx.Done():
+				return
+			case ls.events <- event:
+			}
+			replayed++
+		}
+	}
+}
+
+// channels returns all channels the bot is a member of
+func (ls *Listener) channels() ([]*model.Channel, error) {
+	teams, _, err := ls.client.GetTeamsForUser(ls.botID, "")
+	if err != nil {
+		return nil, err
+	}
+	var all []*model.Channel
+	seen := make(map[string]bool)
+	for _, team := range teams {
+		channels, _, err := ls.client.GetChannelsForTeamForUser(team.Id, ls.botID, false, "")
+		if err != nil {
+			return nil, err
+		}
+		// direct and group channels are returned for every team
+		for _, channel := range channels {
+			if !seen[channel.Id] {
+				seen[channel.Id] = true
+				all = append(all, channel)
+			}
+		}
+	}
+	return all, nil
+}
+
+func (ls *Listener) markPosted(event *model.WebSocketEvent) bool {
+	raw, _ := event.GetData()["post"].(string)
+	post := &model.Post{}
+	if err := json.Unmarshal([]byte(raw), post); err != nil {
+		return true
+	}
+	return ls.markSeen(post.Id, post.CreateAt)
+}
+
+// markSeen remembers the post and reports whether it was not delivered before
+func (ls *Listener) markSeen(postID string, createAt int64) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if _, ok := ls.seen[postID]; ok {
+		return false
+	}
+	ls.seen[postID] = createAt
+	if createAt > ls.lastSeen {
+		ls.lastSeen = createAt
+	}
+	for id, at := range ls.seen {
+		if at < ls.lastSeen-seenWindow.Milliseconds() {
+			delete(ls.seen, id)
+		}
+	}
+	return true
+}
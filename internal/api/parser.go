@@ -0,0 +1,214 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var (
+	errUnterminatedQuote = errors.New("quote is not closed")
+	errWrongArgs         = errors.New("wrong number of arguments")
+	errFlagValue         = errors.New("flag needs a value")
+)
+
+// spec describes arguments and flags of a subcommand, maxArgs -1 means no limit
+type spec struct {
+	usage   string
+	minArgs int
+	maxArgs int
+	// pollArg: the first argument is a poll id
+	pollArg bool
+	// flags: flag name to whether it takes a value
+	flags map[string]bool
+}
+
+var specs = map[string]spec{
+	"create": {
+		usage:   `/poll create "question" "option1" "option2" ["optionN"] [--quorum N|N%] [--threshold N/M|N%] [--deadline 24h] [--remind 1h] [--anonymous] [--max-choices N] [--reactions]`,
+		minArgs: 3,
+		maxArgs: -1,
+		flags: map[string]bool{
+			"quorum":      true,
+			"threshold":   true,
+			"deadline":    true,
+			"remind":      true,
+			"anonymous":   false,
+			"max-choices": true,
+			"reactions":   false,
+		},
+	},
+	"new":      {usage: "/poll new", minArgs: 0, maxArgs: 0},
+	"vote":     {usage: "/poll vote poll_id choice_id", minArgs: 2, maxArgs: 2, pollArg: true},
+	"unvote":   {usage: "/poll unvote poll_id choice_id", minArgs: 2, maxArgs: 2, pollArg: true},
+	"result":   {usage: "/poll result poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"export":   {usage: "/poll export poll_id [csv|json]", minArgs: 1, maxArgs: 2, pollArg: true},
+	"remind":   {usage: "/poll remind poll_id|off|on", minArgs: 1, maxArgs: 1, pollArg: true},
+	"mine":     {usage: "/poll mine", minArgs: 0, maxArgs: 0},
+	"audit":    {usage: "/poll audit poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"pin":      {usage: "/poll pin poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"unpin":    {usage: "/poll unpin poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"end":      {usage: "/poll end poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"delete":   {usage: "/poll delete poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"undelete": {usage: "/poll undelete poll_id", minArgs: 1, maxArgs: 1, pollArg: true},
+	"help":     {usage: "/poll help", minArgs: 0, maxArgs: -1},
+}
+
+// command is a parsed /poll message
+type command struct {
+	name  string
+	args  []string
+	flags map[string]string
+}
+
+// pollID returns the poll the command is about, empty if it does not take a poll id
+func (c *command) pollID() string {
+	if !specs[c.name].pollArg || len(c.args) == 0 {
+		return ""
+	}
+	if c.name == "remind" && (c.args[0] == "off" || c.args[0] == "on") {
+		return ""
+	}
+	return c.args[0]
+}
+
+// usageError tells the user how to call the subcommand
+type usageError struct {
+	usage string
+	err   error
+}
+
+func (e *usageError) Error() string {
+	return fmt.Sprintf("%s\nusage: `%s`", e.err, e.usage)
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+type token struct {
+	value  string
+	quoted bool
+}
+
+// parseCommand parses the /poll message, it returns nil command if the message is not a bot command.
+// Unknown subcommands are parsed as help
+func parseCommand(message string) (*command, error) {
+	tokens, err := tokenize(message)
+	if err != nil {
+		// the message is not tokenized, so the command is recognized by plain fields
+		fields := strings.Fields(message)
+		if len(fields) == 0 || fields[0] != COMMAND {
+			return nil, nil
+		}
+		cmd := &command{name: "help"}
+		if len(fields) > 1 {
+			if _, ok := specs[fields[1]]; ok {
+				cmd.name = fields[1]
+			}
+		}
+		return cmd, &usageError{usage: specs[cmd.name].usage, err: err}
+	}
+	if len(tokens) == 0 || tokens[0].quoted || tokens[0].value != COMMAND {
+		return nil, nil
+	}
+	cmd := &command{name: "help", flags: map[string]string{}}
+	if len(tokens) < 2 {
+		return cmd, nil
+	}
+	if _, ok := specs[tokens[1].value]; !ok || tokens[1].quoted {
+		return cmd, nil
+	}
+	cmd.name = tokens[1].value
+	sp := specs[cmd.name]
+	rest := tokens[2:]
+	for i := 0; i < len(rest); i++ {
+		tok := rest[i]
+		if tok.quoted || !strings.HasPrefix(tok.value, "--") || cmd.name == "help" {
+			cmd.args = append(cmd.args, tok.value)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(tok.value, "--"), "=")
+		takesValue, ok := sp.flags[name]
+		if !ok {
+			return cmd, &usageError{usage: sp.usage, err: fmt.Errorf("%w: --%s", errUnknownFlag, name)}
+		}
+		if takesValue && !hasValue {
+			if i+1 >= len(rest) {
+				return cmd, &usageError{usage: sp.usage, err: fmt.Errorf("%w: --%s", errFlagValue, name)}
+			}
+			i++
+			value = rest[i].value
+		}
+		cmd.flags[name] = value
+	}
+	if len(cmd.args) < sp.minArgs || sp.maxArgs >= 0 && len(cmd.args) > sp.maxArgs {
+		return cmd, &usageError{usage: sp.usage, err: errWrongArgs}
+	}
+	return cmd, nil
+}
+
+// tokenize splits the message by spaces keeping quoted parts together. It accepts straight,
+// curly and angle quotes and backslash escapes, a quoted empty string is kept as an empty token
+func tokenize(message string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+	inToken, quoted := false, false
+	var closing func(rune) bool
+	escaped := false
+	for _, r := range message {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inToken, escaped = true, true
+		case closing != nil:
+			if closing(r) {
+				closing = nil
+			} else {
+				current.WriteRune(r)
+			}
+		case isQuote(r) || r == '«':
+			closing = closingQuote(r)
+			inToken, quoted = true, true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, token{value: current.String(), quoted: quoted})
+				current.Reset()
+				inToken, quoted = false, false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+	if closing != nil {
+		return nil, errUnterminatedQuote
+	}
+	if escaped {
+		current.WriteRune('\\')
+	}
+	if inToken {
+		tokens = append(tokens, token{value: current.String(), quoted: quoted})
+	}
+	return tokens, nil
+}
+
+// isQuote reports whether r is a straight or curly double quote, mobile keyboards
+// replace straight quotes with curly ones and do not always pair them
+func isQuote(r rune) bool {
+	switch r {
+	case '"', '“', '”', '„':
+		return true
+	}
+	return false
+}
+
+func closingQuote(r rune) func(rune) bool {
+	if r == '«' {
+		return func(c rune) bool { return c == '»' }
+	}
+	return isQuote
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []token
+		err     error
+	}{
+		{
+			name:    "plain words",
+			message: "/poll vote abc 1",
+			want:    []token{{value: "/poll"}, {value: "vote"}, {value: "abc"}, {value: "1"}},
+		},
+		{
+			name:    "straight quotes",
+			message: `/poll create "lunch today?" "yes" "no"`,
+			want: []token{{value: "/poll"}, {value: "create"},
+				{value: "lunch today?", quoted: true}, {value: "yes", quoted: true}, {value: "no", quoted: true}},
+		},
+		{
+			name:    "curly quotes",
+			message: "/poll create “lunch today?” „yes“ ”no”",
+			want: []token{{value: "/poll"}, {value: "create"},
+				{value: "lunch today?", quoted: true}, {value: "yes", quoted: true}, {value: "no", quoted: true}},
+		},
+		{
+			name:    "angle quotes",
+			message: "/poll create «обед сегодня?» «да» «нет»",
+			want: []token{{value: "/poll"}, {value: "create"},
+				{value: "обед сегодня?", quoted: true}, {value: "да", quoted: true}, {value: "нет", quoted: true}},
+		},
+		{
+			name:    "escaped quote inside quotes",
+			message: `/poll create "say \"hi\"" a b`,
+			want: []token{{value: "/poll"}, {value: "create"},
+				{value: `say "hi"`, quoted: true}, {value: "a"}, {value: "b"}},
+		},
+		{
+			name:    "escaped backslash",
+			message: `/poll create "C:\\temp" a\ b`,
+			want: []token{{value: "/poll"}, {value: "create"},
+				{value: `C:\temp`, quoted: true}, {value: "a b"}},
+		},
+		{
+			name:    "trailing backslash is kept",
+			message: `/poll result abc\`,
+			want:    []token{{value: "/poll"}, {value: "result"}, {value: `abc\`}},
+		},
+		{
+			name:    "empty quoted token",
+			message: `/poll create "" a`,
+			want:    []token{{value: "/poll"}, {value: "create"}, {value: "", quoted: true}, {value: "a"}},
+		},
+		{
+			name:    "unterminated quote",
+			message: `/poll create "lunch a b`,
+			err:     errUnterminatedQuote,
+		},
+		{
+			name:    "unterminated angle quote",
+			message: "/poll create «lunch a b",
+			err:     errUnterminatedQuote,
+		},
+		{
+			name:    "whitespace only",
+			message: " \t\n ",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tokenize(tt.message)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("tokenize(%q) error = %v, want %v", tt.message, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) = %#v, want %#v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    *command
+		err     error
+		usage   string
+	}{
+		{
+			name:    "not a bot command",
+			message: "hello there",
+		},
+		{
+			name:    "whitespace only",
+			message: "   ",
+		},
+		{
+			name:    "quoted command is not a bot command",
+			message: `"/poll" vote abc 1`,
+		},
+		{
+			name:    "bare command is help",
+			message: "/poll",
+			want:    &command{name: "help", flags: map[string]string{}},
+		},
+		{
+			name:    "unknown subcommand is help",
+			message: "/poll frobnicate abc",
+			want:    &command{name: "help", flags: map[string]string{}},
+		},
+		{
+			name:    "create with curly quotes and flags",
+			message: "/poll create “lunch?” “yes” “no” --quorum 3 --threshold=2/3 --anonymous",
+			want: &command{
+				name:  "create",
+				args:  []string{"lunch?", "yes", "no"},
+				flags: map[string]string{"quorum": "3", "threshold": "2/3", "anonymous": ""},
+			},
+		},
+		{
+			name:    "flag value with equals sign",
+			message: `/poll create "q" "a" "b" --deadline=24h --remind=1h`,
+			want: &command{
+				name:  "create",
+				args:  []string{"q", "a", "b"},
+				flags: map[string]string{"deadline": "24h", "remind": "1h"},
+			},
+		},
+		{
+			name:    "flag value as the next token",
+			message: `/poll create "q" "a" "b" --max-choices 2 --reactions`,
+			want: &command{
+				name:  "create",
+				args:  []string{"q", "a", "b"},
+				flags: map[string]string{"max-choices": "2", "reactions": ""},
+			},
+		},
+		{
+			name:    "quoted option starting with dashes is an argument",
+			message: `/poll create "q" "--yes" "no"`,
+			want: &command{
+				name:  "create",
+				args:  []string{"q", "--yes", "no"},
+				flags: map[string]string{},
+			},
+		},
+		{
+			name:    "vote",
+			message: "/poll vote abc 1",
+			want:    &command{name: "vote", args: []string{"abc", "1"}, flags: map[string]string{}},
+		},
+		{
+			name:    "export with format",
+			message: "/poll export abc csv",
+			want:    &command{name: "export", args: []string{"abc", "csv"}, flags: map[string]string{}},
+		},
+		{
+			name:    "unknown flag",
+			message: `/poll create "q" "a" "b" --secret`,
+			err:     errUnknownFlag,
+			usage:   specs["create"].usage,
+		},
+		{
+			name:    "flag of another subcommand",
+			message: "/poll vote abc 1 --anonymous",
+			err:     errUnknownFlag,
+			usage:   specs["vote"].usage,
+		},
+		{
+			name:    "flag without value",
+			message: `/poll create "q" "a" "b" --quorum`,
+			err:     errFlagValue,
+			usage:   specs["create"].usage,
+		},
+		{
+			name:    "unterminated quote keeps the subcommand usage",
+			message: `/poll create "q "a" "b"`,
+			err:     errUnterminatedQuote,
+			usage:   specs["create"].usage,
+		},
+		{
+			name:    "unterminated quote of unknown subcommand",
+			message: `/poll frobnicate "q`,
+			err:     errUnterminatedQuote,
+			usage:   specs["help"].usage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCommand(tt.message)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseCommand(%q) error = %v, want %v", tt.message, err, tt.err)
+			}
+			if tt.err != nil {
+				assertUsage(t, err, tt.usage)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCommand(%q) = %#v, want %#v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandArgsCount(t *testing.T) {
+	tests := []struct {
+		subcommand string
+		args       string
+	}{
+		{subcommand: "create", args: `"q" "only one option"`},
+		{subcommand: "new", args: "abc"},
+		{subcommand: "vote", args: "abc"},
+		{subcommand: "vote", args: "abc 1 2"},
+		{subcommand: "unvote", args: "abc"},
+		{subcommand: "result", args: ""},
+		{subcommand: "result", args: "abc def"},
+		{subcommand: "export", args: ""},
+		{subcommand: "export", args: "abc csv extra"},
+		{subcommand: "remind", args: ""},
+		{subcommand: "mine", args: "abc"},
+		{subcommand: "audit", args: ""},
+		{subcommand: "pin", args: ""},
+		{subcommand: "unpin", args: "abc def"},
+		{subcommand: "end", args: ""},
+		{subcommand: "delete", args: ""},
+		{subcommand: "undelete", args: "abc def"},
+	}
+	for _, tt := range tests {
+		message := strings.TrimSpace("/poll " + tt.subcommand + " " + tt.args)
+		t.Run(message, func(t *testing.T) {
+			cmd, err := parseCommand(message)
+			if !errors.Is(err, errWrongArgs) {
+				t.Fatalf("parseCommand(%q) error = %v, want %v", message, err, errWrongArgs)
+			}
+			if cmd == nil || cmd.name != tt.subcommand {
+				t.Fatalf("parseCommand(%q) command = %#v, want %s", message, cmd, tt.subcommand)
+			}
+			assertUsage(t, err, specs[tt.subcommand].usage)
+		})
+	}
+}
+
+func TestCommandPollID(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "/poll vote abc 1", want: "abc"},
+		{message: "/poll result abc", want: "abc"},
+		{message: "/poll export abc json", want: "abc"},
+		{message: "/poll remind abc", want: "abc"},
+		{message: "/poll remind off", want: ""},
+		{message: "/poll remind on", want: ""},
+		{message: "/poll undelete abc", want: "abc"},
+		{message: `/poll create "q" "a" "b"`, want: ""},
+		{message: "/poll mine", want: ""},
+		{message: "/poll help vote", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			cmd, err := parseCommand(tt.message)
+			if err != nil {
+				t.Fatalf("parseCommand(%q) error = %v", tt.message, err)
+			}
+			if got := cmd.pollID(); got != tt.want {
+				t.Errorf("pollID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func assertUsage(t *testing.T, err error, usage string) {
+	t.Helper()
+	var uerr *usageError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("error %v is not a usage error", err)
+	}
+	if uerr.usage != usage {
+		t.Errorf("usage = %q, want %q", uerr.usage, usage)
+	}
+	if !strings.Contains(err.Error(), usage) {
+		t.Errorf("error message %q does not contain the usage", err.Error())
+	}
+}
@@ -4,36 +4,94 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/metrics"
 	"github.com/jaam8/mattermost_bot/internal/models"
 	"github.com/jaam8/mattermost_bot/internal/service"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"go.uber.org/zap"
-	"strconv"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	COMMAND     = "/poll"
-	HelpMessage = "i know only this command:\n- `/poll create \"question\" \"option1\" \"option2\" \"optionN\"`\n- `/poll vote poll_id choice_id`\n- `/poll result poll_id`\n- `/poll end poll_id`\n- `/poll delete poll_id`\n- `/poll help`"
+	HelpMessage = "i know only this command:\n- `/poll create \"question\" \"option1\" \"option2\" \"optionN\" [--quorum N|N%] [--threshold N/M|N%] [--deadline 24h] [--remind 1h] [--anonymous] [--max-choices N] [--reactions]`\n- `/poll new`\n- `/poll vote poll_id choice_id`\n- `/poll unvote poll_id choice_id`\n- `/poll result poll_id`\n- `/poll export poll_id [csv|json]`\n- `/poll remind poll_id|off|on`\n- `/poll mine`\n- `/poll audit poll_id`\n- `/poll pin poll_id`\n- `/poll unpin poll_id`\n- `/poll end poll_id`\n- `/poll delete poll_id`\n- `/poll undelete poll_id`\n- `/poll help`"
 )
 
+var errDialogsDisabled = errors.New("dialogs are not configured, use `/poll create` instead")
+
+type Config struct {
+	// BotURL: address of the bot http server reachable from Mattermost, required for dialogs
+	BotURL string `yaml:"BOT_URL" env:"BOT_URL"`
+	// DialogSecret: key signing dialog requests, random if empty, should be set for several replicas
+	DialogSecret string `yaml:"DIALOG_SECRET" env:"DIALOG_SECRET"`
+}
+
 type PollHandler struct {
-	s      *service.PollService
-	l      *zap.Logger
-	client *model.Client4
+	s       *service.PollService
+	limiter *service.RateLimiter
+	l       *zap.Logger
+	client  *model.Client4
+	botID   string
+	cfg     Config
+	secret  []byte
+	// pollPosts: post id to id of the poll announced in it
+	pollPosts sync.Map
+	// removedReactions: reactions removed by the bot to the time of removal
+	removedReactions sync.Map
+	// pollChannels: poll id to its channel, the channel of a poll never changes
+	pollChannels sync.Map
 }
 
-func New(s *service.PollService, l *zap.Logger, client *model.Client4) *PollHandler {
+func New(s *service.PollService, limiter *service.RateLimiter, l *zap.Logger, client *model.Client4,
+	botID string, cfg Config) *PollHandler {
+	secret := []byte(cfg.DialogSecret)
+	if len(secret) == 0 {
+		secret = []byte(model.NewRandomString(32))
+	}
+	cfg.BotURL = strings.TrimSuffix(cfg.BotURL, "/")
 	return &PollHandler{
-		s:      s,
-		l:      l,
-		client: client,
+		s:       s,
+		limiter: limiter,
+		l:       l,
+		client:  client,
+		botID:   botID,
+		cfg:     cfg,
+		secret:  secret,
+	}
+}
+
+// EventKey returns the key that orders event processing: commands for the same poll are handled
+// one by one, reactions are ordered per post and other commands per user. It runs on the dispatcher,
+// so it does not read the storage, the poll of a reaction is found by the worker
+func (h *PollHandler) EventKey(event *model.WebSocketEvent) string {
+	switch event.EventType() {
+	case model.WebsocketEventReactionAdded, model.WebsocketEventReactionRemoved:
+		reaction := &model.Reaction{}
+		raw, _ := event.GetData()["reaction"].(string)
+		if err := json.Unmarshal([]byte(raw), reaction); err != nil {
+			return ""
+		}
+		return "post:" + reaction.PostId
 	}
+	post := &model.Post{}
+	raw, _ := event.GetData()["post"].(string)
+	if err := json.Unmarshal([]byte(raw), post); err != nil {
+		return ""
+	}
+	cmd, err := parseCommand(post.Message)
+	if err == nil && cmd != nil && cmd.name != "create" && cmd.name != "help" && len(cmd.args) > 0 {
+		return "poll:" + cmd.args[0]
+	}
+	return "user:" + post.UserId
 }
 
 func HandleMessage(h *PollHandler, event *model.WebSocketEvent, botID string) {
 	post := &model.Post{}
-	err := json.Unmarshal([]byte(event.GetData()["post"].(string)), &post)
+	raw, _ := event.GetData()["post"].(string)
+	err := json.Unmarshal([]byte(raw), &post)
 	if err != nil {
 		h.l.Error("error unmarshalling post", zap.Error(err))
 		return
@@ -46,40 +104,68 @@ func HandleMessage(h *PollHandler, event *model.WebSocketEvent, botID string) {
 		return
 	}
 
-	args := strings.Fields(post.Message)
-	if args[0] != COMMAND {
-		//h.l.Error("this HelpMessage has not command")
+	cmd, err := parseCommand(post.Message)
+	if cmd == nil {
 		return
 	}
-	if len(args) < 2 {
-		err = h.SendMsg(HelpMessage, post.ChannelId)
-		return
+	// commands sent to the bot directly do not fall back to the direct channel for polls without one
+	pollChannel := post.ChannelId
+	if channelType, _ := event.GetData()["channel_type"].(string); channelType == string(model.ChannelTypeDirect) {
+		pollChannel = ""
 	}
 	h.l.Info("new request for the bot",
-		zap.String("command", args[0]),
-		zap.String("action", args[1]),
+		zap.String("command", COMMAND),
+		zap.String("action", cmd.name),
 		zap.String("user_id", post.UserId),
 		zap.String("channel_id", post.ChannelId),
 		zap.String("message", post.Message))
-	switch args[1] {
-	case "create":
-		createArgs := []string{}
-		for i, val := range strings.Split(post.Message, "\"") {
-			if i%2 != 0 {
-				createArgs = append(createArgs, strings.Trim(val, "\""))
+	usage := false
+	defer func() {
+		metrics.ObserveCommand(cmd.name, err, usage)
+	}()
+	if !h.allow(cmd.name, post.UserId, post.ChannelId) {
+		err = models.ErrRateLimited
+		h.replyEphemeral(post, &model.PostEphemeral{UserID: post.UserId,
+			Post: &model.Post{ChannelId: post.ChannelId, Message: err.Error()}})
+		return
+	}
+	if err != nil {
+		usage = true
+		h.replyEphemeral(post, &model.PostEphemeral{UserID: post.UserId,
+			Post: &model.Post{ChannelId: post.ChannelId, Message: err.Error()}})
+		return
+	}
+	if pollID := cmd.pollID(); pollID != "" {
+		if err = h.checkPollChannel(pollID, post.UserId, post.ChannelId); err != nil {
+			message := "somthing went wrong"
+			if errors.Is(err, models.ErrNotChannelMember) {
+				message = err.Error()
 			}
-		}
-		respPost := &model.PostEphemeral{UserID: post.UserId}
-		if len(args) <= 4 {
-			respPost.Post = &model.Post{ChannelId: post.ChannelId,
-				Message: HelpMessage}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+			h.replyEphemeral(post, &model.PostEphemeral{UserID: post.UserId,
+				Post: &model.Post{ChannelId: post.ChannelId, Message: message}})
 			return
 		}
-		err = h.CreatePoll(createArgs[0], post.UserId, post.ChannelId, createArgs[1:])
+	}
+	args := cmd.args
+	switch cmd.name {
+	case "create":
+		var settings models.Settings
+		settings, err = parseSettings(cmd.flags)
+		if err == nil {
+			err = h.CreatePoll(args[0], post.UserId, post.ChannelId, post.RootId, args[1:], settings)
+		}
 		if err != nil {
 			errPost := &model.PostEphemeral{UserID: post.UserId}
 			switch {
+			case errors.Is(err, models.ErrInvalidQuorum),
+				errors.Is(err, models.ErrInvalidThreshold),
+				errors.Is(err, models.ErrInvalidDeadline),
+				errors.Is(err, models.ErrInvalidRemind),
+				errors.Is(err, models.ErrInvalidMaxChoices),
+				errors.Is(err, models.ErrInvalidReactions),
+				errors.Is(err, models.ErrAnonymousReactions):
+				errPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
 			case errors.Is(err, models.ErrNotEnoughOptions):
 				errPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: err.Error()}
@@ -93,112 +179,228 @@ func HandleMessage(h *PollHandler, event *model.WebSocketEvent, botID string) {
 				errPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: "somthing went wrong"}
 			}
-			_, _, _ = h.client.CreatePostEphemeral(errPost)
+			h.replyEphemeral(post, errPost)
 			return
 		}
+	case "new":
+		if err = h.SendNewPollButton(post.UserId, post.ChannelId, post.RootId); err != nil {
+			h.replyEphemeral(post, &model.PostEphemeral{UserID: post.UserId,
+				Post: &model.Post{ChannelId: post.ChannelId, Message: err.Error()}})
+		}
 	case "vote":
 		respPost := &model.PostEphemeral{UserID: post.UserId}
-		h.l.Debug("len args", zap.Int("len(args)", len(args)))
-		if len(args) != 4 {
-			respPost.Post = &model.Post{ChannelId: post.ChannelId,
-				Message: HelpMessage}
-			_, _, err = h.client.CreatePostEphemeral(respPost)
-			if err != nil {
-				h.l.Error("error sending message", zap.Error(err))
-				return
+		err = h.Vote(args[0], args[1], post.UserId)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			case errors.Is(err, models.ErrOptionIsNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found option with id: %s", args[1])}
+			case errors.Is(err, models.ErrVoteAlreadyExists), errors.Is(err, models.ErrTooManyChoices):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
+			case errors.Is(err, models.ErrPollIsEnd):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("poll with id: %s is ended", args[0])}
+			default:
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: "somthing went wrong"}
 			}
+			h.replyEphemeral(post, respPost)
 			return
 		}
-		err = h.Vote(args[2], args[3], post.UserId)
+		respPost.Post = &model.Post{ChannelId: post.ChannelId,
+			Message: "your vote successfully written"}
+		h.replyEphemeral(post, respPost)
+	case "unvote":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		err = h.Unvote(args[0], args[1], post.UserId)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrPollNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("not found poll with id: %s", args[2])}
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
 			case errors.Is(err, models.ErrOptionIsNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("not found option with id: %s", args[3])}
-			case errors.Is(err, models.ErrVoteAlreadyExists):
+					Message: fmt.Sprintf("not found option with id: %s", args[1])}
+			case errors.Is(err, models.ErrVoteNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: err.Error()}
 			case errors.Is(err, models.ErrPollIsEnd):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("poll with id: %s is ended", args[2])}
+					Message: fmt.Sprintf("poll with id: %s is ended", args[0])}
 			default:
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: "somthing went wrong"}
 			}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+			h.replyEphemeral(post, respPost)
 			return
 		}
 		respPost.Post = &model.Post{ChannelId: post.ChannelId,
-			Message: "your vote successfully written"}
-		_, _, _ = h.client.CreatePostEphemeral(respPost)
+			Message: "your vote is removed"}
+		h.replyEphemeral(post, respPost)
 	case "result":
 		respPost := &model.PostEphemeral{UserID: post.UserId}
-		if len(args) != 3 {
-			respPost.Post = &model.Post{ChannelId: post.ChannelId,
-				Message: HelpMessage}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+		err = h.GetPollResult(args[0], post.ChannelId, post.RootId)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			default:
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: "somthing went wrong"}
+			}
+			h.replyEphemeral(post, respPost)
 			return
 		}
-		err = h.GetPollResult(args[2], post.ChannelId)
+	case "end":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		err = h.EndPoll(args[0], post.UserId, pollChannel)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrPollNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("not found poll with id: %s", args[2])}
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			case errors.Is(err, models.ErrUserNotOwner):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
+			case errors.Is(err, models.ErrPollAlreadyEnded):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
 			default:
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: "somthing went wrong"}
 			}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+			h.replyEphemeral(post, respPost)
 			return
 		}
-	case "end":
+		respPost.Post = &model.Post{ChannelId: post.ChannelId,
+			Message: withLink("poll successfully ended", h.pollLinkByID(args[0]))}
+		h.replyEphemeral(post, respPost)
+	case "remind":
 		respPost := &model.PostEphemeral{UserID: post.UserId}
-		if len(args) != 3 {
+		if args[0] == "off" || args[0] == "on" {
+			optOut := args[0] == "off"
 			respPost.Post = &model.Post{ChannelId: post.ChannelId,
-				Message: HelpMessage}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+				Message: "reminders are turned " + args[0]}
+			if err = h.s.SetReminderOptOut(post.UserId, optOut); err != nil {
+				h.l.Error("failed to set reminder opt-out", zap.Error(err))
+				respPost.Post.Message = "somthing went wrong"
+			}
+			h.replyEphemeral(post, respPost)
 			return
 		}
-		err = h.EndPoll(args[2], post.UserId)
+		var sent int
+		sent, err = h.Remind(args[0], post.UserId, pollChannel)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrPollNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("not found poll with id: %s", args[2])}
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
 			case errors.Is(err, models.ErrUserNotOwner):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: err.Error()}
-			case errors.Is(err, models.ErrPollAlreadyEnded):
+			case errors.Is(err, models.ErrPollIsEnd):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("poll with id: %s is ended", args[0])}
+			case errors.Is(err, errNoPollChannel):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: err.Error()}
 			default:
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: "somthing went wrong"}
 			}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+			h.replyEphemeral(post, respPost)
 			return
 		}
 		respPost.Post = &model.Post{ChannelId: post.ChannelId,
-			Message: "poll successfully ended"}
-		_, _, _ = h.client.CreatePostEphemeral(respPost)
-	case "delete":
+			Message: withLink(fmt.Sprintf("reminded %d members", sent), h.pollLinkByID(args[0]))}
+		h.replyEphemeral(post, respPost)
+	case "export":
 		respPost := &model.PostEphemeral{UserID: post.UserId}
-		if len(args) != 3 {
-			respPost.Post = &model.Post{ChannelId: post.ChannelId,
-				Message: HelpMessage}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+		format := "csv"
+		if len(args) == 2 {
+			format = args[1]
+		}
+		err = h.Export(args[0], post.UserId, format)
+		usage = errors.Is(err, errUnknownFormat)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			case errors.Is(err, models.ErrUserNotOwner), errors.Is(err, errUnknownFormat):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
+			default:
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: "somthing went wrong"}
+			}
+			h.replyEphemeral(post, respPost)
+			return
+		}
+		respPost.Post = &model.Post{ChannelId: post.ChannelId,
+			Message: withLink("export is sent to your direct messages", h.pollLinkByID(args[0]))}
+		h.replyEphemeral(post, respPost)
+	case "mine":
+		var message string
+		if message, err = h.MyPolls(post.UserId); err != nil {
+			message = "somthing went wrong"
+		}
+		h.replyEphemeral(post, &model.PostEphemeral{UserID: post.UserId,
+			Post: &model.Post{ChannelId: post.ChannelId, Message: message}})
+	case "audit":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		var message string
+		message, err = h.Audit(args[0], post.UserId)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				message = fmt.Sprintf("not found poll with id: %s", args[0])
+			case errors.Is(err, models.ErrUserNotOwner):
+				message = err.Error()
+			default:
+				message = "somthing went wrong"
+			}
+		}
+		respPost.Post = &model.Post{ChannelId: post.ChannelId, Message: message}
+		h.replyEphemeral(post, respPost)
+	case "pin", "unpin":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		pinned := cmd.name == "pin"
+		err = h.PinPoll(args[0], post.UserId, pinned)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			case errors.Is(err, models.ErrUserNotOwner):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
+			default:
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: "somthing went wrong"}
+			}
+			h.replyEphemeral(post, respPost)
 			return
 		}
-		err = h.DeletePoll(args[2], post.UserId)
+		message := "poll is pinned and will be kept forever"
+		if !pinned {
+			message = "poll is unpinned, the retention policy applies to it again"
+		}
+		respPost.Post = &model.Post{ChannelId: post.ChannelId, Message: message}
+		h.replyEphemeral(post, respPost)
+	case "delete":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		err = h.DeletePoll(args[0], post.UserId)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrPollNotFound):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
-					Message: fmt.Sprintf("not found poll with id: %s", args[2])}
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
 			case errors.Is(err, models.ErrUserNotOwner):
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: err.Error()}
@@ -206,60 +408,132 @@ func HandleMessage(h *PollHandler, event *model.WebSocketEvent, botID string) {
 				respPost.Post = &model.Post{ChannelId: post.ChannelId,
 					Message: "somthing went wrong"}
 			}
-			_, _, _ = h.client.CreatePostEphemeral(respPost)
+			h.replyEphemeral(post, respPost)
+			return
+		}
+		respPost.Post = &model.Post{ChannelId: post.ChannelId,
+			Message: fmt.Sprintf("poll successfully deleted, restore it with `/poll undelete %s` within %s",
+				args[0], formatDuration(h.s.UndeleteWindow()))}
+		h.replyEphemeral(post, respPost)
+	case "undelete":
+		respPost := &model.PostEphemeral{UserID: post.UserId}
+		err = h.UndeletePoll(args[0], post.UserId)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPollNotFound):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: fmt.Sprintf("not found poll with id: %s", args[0])}
+			case errors.Is(err, models.ErrUserNotOwner), errors.Is(err, models.ErrPollNotDeleted),
+				errors.Is(err, models.ErrUndeleteExpired):
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: err.Error()}
+			default:
+				respPost.Post = &model.Post{ChannelId: post.ChannelId,
+					Message: "somthing went wrong"}
+			}
+			h.replyEphemeral(post, respPost)
 			return
 		}
 		respPost.Post = &model.Post{ChannelId: post.ChannelId,
-			Message: "poll successfully deleted"}
-		_, _, _ = h.client.CreatePostEphemeral(respPost)
+			Message: withLink("poll successfully restored", h.pollLinkByID(args[0]))}
+		h.replyEphemeral(post, respPost)
 	default:
-		err = h.SendMsg(HelpMessage, post.ChannelId)
+		_, err = h.sendPost(&model.Post{ChannelId: post.ChannelId, RootId: post.RootId, Message: HelpMessage})
 	}
 
 }
 
-func (h *PollHandler) CreatePoll(question, creatorID, channelID string, optionsRaw []string) error {
-	if len(question) < 1 {
-		return models.ErrQuestionIsEmpty
+// allow reports whether the user may run the subcommand, commands are let through if the limiter fails
+func (h *PollHandler) allow(command, userID, channelID string) bool {
+	if h.limiter == nil {
+		return true
+	}
+	allowed, err := h.limiter.Allow(command, userID, channelID)
+	if err != nil {
+		h.l.Error("failed to check rate limit",
+			zap.String("command", command),
+			zap.String("user_id", userID),
+			zap.Error(err))
+		return true
 	}
-	if len(optionsRaw) < 2 {
-		return models.ErrNotEnoughOptions
+	if !allowed {
+		h.l.Info("command is rate limited",
+			zap.String("command", command),
+			zap.String("user_id", userID),
+			zap.String("channel_id", channelID))
 	}
+	return allowed
+}
+
+// CreatePoll creates the poll and announces it, rootID is the thread the command was sent in
+func (h *PollHandler) CreatePoll(question, creatorID, channelID, rootID string, optionsRaw []string,
+	settings models.Settings) error {
 	h.l.Debug("data for creating new poll",
 		zap.String("question", question),
 		zap.String("creator_id", creatorID),
 		zap.Strings("options", optionsRaw))
-	id, options, err := h.s.CreatePoll(question, creatorID, optionsRaw)
+	poll, err := h.s.CreatePoll(question, creatorID, channelID, optionsRaw, settings)
 	if err != nil {
-		if errors.Is(err, models.ErrOptionIsEmpty) {
-			h.l.Warn("option is empty")
+		switch {
+		case errors.Is(err, models.ErrQuestionIsEmpty), errors.Is(err, models.ErrNotEnoughOptions),
+			errors.Is(err, models.ErrOptionIsEmpty):
+			h.l.Warn("invalid poll", zap.Error(err))
+			return err
+		case errors.Is(err, models.ErrInvalidQuorum), errors.Is(err, models.ErrInvalidThreshold),
+			errors.Is(err, models.ErrInvalidDeadline), errors.Is(err, models.ErrInvalidRemind),
+			errors.Is(err, models.ErrInvalidMaxChoices), errors.Is(err, models.ErrInvalidReactions),
+			errors.Is(err, models.ErrAnonymousReactions):
+			h.l.Warn("invalid poll rules", zap.Any("settings", settings))
 			return err
 		}
 		h.l.Error("failed creating poll", zap.Error(err))
 		return fmt.Errorf("handler: failed to create poll: %w", err)
 	}
-	message := fmt.Sprintf("**Poll ID**: %s\n**Question**: %s\n**Options**:\n", id, question)
-	for _, option := range options {
-		message += fmt.Sprintf("  [%d] *%s*\n", option.ID, option.Text)
+	poll.RootID = rootID
+	if err = h.Announce(poll); err != nil {
+		return err
 	}
-	if err = h.SendMsg(message, channelID); err != nil {
+	h.l.Info("successfully created poll",
+		zap.String("poll_id", poll.ID),
+		zap.String("question", question),
+		zap.Any("options", poll.Options))
+	return nil
+}
+
+// Announce posts the poll to its channel, or to the thread in poll.RootID, and saves the post id
+// for live updates and the thread root for results and reminders
+func (h *PollHandler) Announce(poll *models.Poll) error {
+	post, err := h.sendPost(&model.Post{
+		ChannelId: poll.ChannelID,
+		RootId:    poll.RootID,
+		Message:   pollMessage(poll),
+	})
+	if err != nil {
 		h.l.Error("failed sending poll message", zap.Error(err))
 		return fmt.Errorf("handler: failed to send message: %w", err)
 	}
-	h.l.Info("successfully created poll",
-		zap.String("poll_id", id),
-		zap.String("question", question),
-		zap.Any("options", options))
+	poll.PostID = post.Id
+	if poll.RootID == "" {
+		poll.RootID = post.Id
+	}
+	if err = h.s.SetPostID(poll.ID, post.Id, poll.RootID); err != nil {
+		// reminders will point to the poll id instead of the post
+		h.l.Warn("failed to save poll post id", zap.Error(err))
+	}
+	h.pollPosts.Store(post.Id, poll.ID)
+	if poll.Settings.Reactions {
+		h.addReactions(poll)
+	}
 	return nil
 }
 
-func (h *PollHandler) GetPollResult(pollID, channelID string) error {
-	question, options, votes, err := h.s.GetPollResult(pollID)
+// GetPollResult posts the results to the thread the command was sent in, commands sent
+// at the root of the poll channel are answered in the poll thread
+func (h *PollHandler) GetPollResult(pollID, channelID, rootID string) error {
+	poll, err := h.s.GetPollResult(pollID)
 	h.l.Debug("data for getting poll result",
 		zap.String("poll_id", pollID),
-		zap.String("question", question),
-		zap.Any("options", options),
-		zap.Any("votes", votes))
+		zap.Any("poll", poll))
 	if err != nil {
 		if errors.Is(err, models.ErrPollNotFound) {
 			h.l.Warn("poll not found", zap.String("poll_id", pollID))
@@ -270,12 +544,26 @@ func (h *PollHandler) GetPollResult(pollID, channelID string) error {
 			zap.Error(err))
 		return fmt.Errorf("handler: failed to get poll result: %w", err)
 	}
-	message := fmt.Sprintf("**Question**: %s\n", question)
-	for _, option := range options {
-		message += fmt.Sprintf("  [%d] votes: **%d** (*%s*)\n",
-			option.ID, votes[strconv.Itoa(option.ID)], option.Text)
+	res := newResults(poll)
+	if rootID == "" && poll.ChannelID == channelID {
+		rootID = poll.ThreadID()
+	}
+	post := &model.Post{
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message:   fmt.Sprintf("**Question**: %s\n", poll.Question) + res.status(),
+	}
+	fileID, err := h.uploadChart(res, channelID)
+	if err != nil {
+		h.l.Warn("failed to upload results chart, sending text results",
+			zap.String("poll_id", pollID),
+			zap.Error(err))
+		post.Message += res.bars()
+	} else {
+		post.FileIds = model.StringArray{fileID}
 	}
-	if err = h.SendMsg(message, channelID); err != nil {
+	post.Message += res.summary()
+	if _, err = h.sendPost(post); err != nil {
 		h.l.Error("error sending message", zap.Error(err))
 		return err
 	}
@@ -297,7 +585,7 @@ func (h *PollHandler) Vote(pollID, choiceID, userID string) error {
 		case errors.Is(err, models.ErrOptionIsNotFound):
 			h.l.Warn("option not found", zap.String("choice_id", choiceID))
 			return err
-		case errors.Is(err, models.ErrVoteAlreadyExists):
+		case errors.Is(err, models.ErrVoteAlreadyExists), errors.Is(err, models.ErrTooManyChoices):
 			h.l.Warn("vote already exists",
 				zap.String("poll_id", pollID),
 				zap.String("choice_id", choiceID))
@@ -320,14 +608,15 @@ func (h *PollHandler) Vote(pollID, choiceID, userID string) error {
 		zap.String("poll_id", pollID),
 		zap.String("user_id", userID),
 		zap.String("choice_id", choiceID))
+	h.RefreshPollPost(pollID)
 	return nil
 }
 
-func (h *PollHandler) EndPoll(pollID, userID string) error {
+func (h *PollHandler) EndPoll(pollID, userID, channelID string) error {
 	h.l.Debug("data for ending poll",
 		zap.String("poll_id", pollID),
 		zap.String("user_id", userID))
-	err := h.s.EndPoll(pollID, userID)
+	poll, decision, err := h.s.EndPoll(pollID, userID, h.CountMembers)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrPollNotFound):
@@ -354,9 +643,30 @@ func (h *PollHandler) EndPoll(pollID, userID string) error {
 	h.l.Info("successfully ended poll",
 		zap.String("poll_id", pollID),
 		zap.String("user_id", userID))
+	if poll.ChannelID == "" {
+		poll.ChannelID = channelID
+	}
+	h.AnnounceEnd(poll, decision)
 	return nil
 }
 
+// AnnounceEnd updates the poll post with final results, decision polls get the final message
+// with the result in the poll channel
+func (h *PollHandler) AnnounceEnd(poll *models.Poll, decision *models.Decision) {
+	h.updatePollPost(poll)
+	if decision == nil || poll.ChannelID == "" {
+		return
+	}
+	if err := h.sendThread(finalMessage(poll, decision, false), poll); err != nil {
+		// the poll is already ended, so the owner still gets a success reply
+		h.l.Error("failed sending poll decision", zap.Error(err))
+		return
+	}
+	h.l.Info("sent poll decision",
+		zap.String("poll_id", poll.ID),
+		zap.String("outcome", string(decision.Outcome)))
+}
+
 func (h *PollHandler) DeletePoll(pollID, userID string) error {
 	h.l.Debug("data for deleting poll",
 		zap.String("poll_id", pollID),
@@ -386,18 +696,204 @@ func (h *PollHandler) DeletePoll(pollID, userID string) error {
 	return nil
 }
 
+// PinPoll keeps the poll from the retention policy or releases it
+func (h *PollHandler) PinPoll(pollID, userID string, pinned bool) error {
+	if err := h.s.PinPoll(pollID, userID, h.isAdmin(userID), pinned); err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			h.l.Warn("poll not found", zap.String("poll_id", pollID))
+			return err
+		case errors.Is(err, models.ErrUserNotOwner):
+			h.l.Warn("user is not owner of poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			return err
+		default:
+			h.l.Error("failed to pin poll",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+			return fmt.Errorf("handler: failed to pin poll: %w", err)
+		}
+	}
+	h.l.Info("changed poll pin",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID),
+		zap.Bool("pinned", pinned))
+	return nil
+}
+
+func (h *PollHandler) UndeletePoll(pollID, userID string) error {
+	h.l.Debug("data for undeleting poll",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID))
+	poll, err := h.s.UndeletePoll(pollID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound), errors.Is(err, models.ErrPollNotDeleted),
+			errors.Is(err, models.ErrUndeleteExpired):
+			h.l.Warn("poll can not be restored",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+			return err
+		case errors.Is(err, models.ErrUserNotOwner):
+			h.l.Warn("user is not owner of poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			return err
+		default:
+			h.l.Error("failed to undelete poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID),
+				zap.Error(err))
+			return fmt.Errorf("handler: failed to undelete poll: %w", err)
+		}
+	}
+	h.l.Info("successfully restored poll",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID))
+	h.updatePollPost(poll)
+	return nil
+}
+
 func (h *PollHandler) SendMsg(message, channelID string) error {
-	post := &model.Post{
+	_, err := h.createPost(message, channelID)
+	return err
+}
+
+func (h *PollHandler) createPost(message, channelID string) (*model.Post, error) {
+	return h.sendPost(&model.Post{
 		ChannelId: channelID,
 		Message:   message,
-	}
-	req, resp, err := h.client.CreatePost(post)
+	})
+}
+
+// sendThread replies in the poll thread
+func (h *PollHandler) sendThread(message string, poll *models.Poll) error {
+	_, err := h.sendPost(&model.Post{
+		ChannelId: poll.ChannelID,
+		RootId:    poll.ThreadID(),
+		Message:   message,
+	})
+	return err
+}
+
+func (h *PollHandler) sendPost(post *model.Post) (*model.Post, error) {
+	start := time.Now()
+	created, resp, err := h.client.CreatePost(post)
+	code := statusCode(resp)
+	metrics.ObserveMattermost("CreatePost", start, code)
 	h.l.Debug("send new message",
-		zap.String("channel_id", req.ChannelId),
-		zap.String("message", req.Message),
-		zap.Int("status_code", resp.StatusCode))
+		zap.String("channel_id", post.ChannelId),
+		zap.String("message", post.Message),
+		zap.Int("status_code", code))
 	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (h *PollHandler) sendEphemeral(post *model.PostEphemeral) {
+	start := time.Now()
+	_, resp, err := h.client.CreatePostEphemeral(post)
+	metrics.ObserveMattermost("CreatePostEphemeral", start, statusCode(resp))
+	if err != nil {
+		h.l.Error("error sending ephemeral message",
+			zap.String("user_id", post.UserID),
+			zap.Error(err))
+	}
+}
+
+// replyEphemeral sends the ephemeral response to the thread of the command post
+func (h *PollHandler) replyEphemeral(post *model.Post, resp *model.PostEphemeral) {
+	resp.Post.RootId = post.RootId
+	h.sendEphemeral(resp)
+}
+
+func statusCode(resp *model.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// CountMembers returns the number of channel members without the bot itself
+func (h *PollHandler) CountMembers(channelID string) (int, error) {
+	stats, _, err := h.client.GetChannelStats(channelID, "")
+	if err != nil {
+		return 0, err
+	}
+	return int(stats.MemberCount) - 1, nil
+}
+
+// IsChannelMember reports whether the user is a member of the channel, channels the bot
+// can not see are treated as channels without the user
+func (h *PollHandler) IsChannelMember(channelID, userID string) (bool, error) {
+	_, resp, err := h.client.GetChannelMember(channelID, userID, "")
+	if err != nil {
+		switch statusCode(resp) {
+		case http.StatusNotFound, http.StatusForbidden:
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkPollChannel returns ErrNotChannelMember if the command is sent outside the poll channel by a user
+// who is not its member, so a known poll id gives no access to polls of other channels. Missing polls
+// pass, the command reports them
+func (h *PollHandler) checkPollChannel(pollID, userID, channelID string) error {
+	pollChannel, ok := h.pollChannels.Load(pollID)
+	if !ok {
+		poll, err := h.s.GetPollResult(pollID)
+		if errors.Is(err, models.ErrPollNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pollChannel = poll.ChannelID
+		h.pollChannels.Store(pollID, pollChannel)
+	}
+	if pollChannel == "" || pollChannel == channelID {
+		return nil
+	}
+	member, err := h.IsChannelMember(pollChannel.(string), userID)
+	if err != nil {
+		h.l.Error("failed to check channel membership",
+			zap.String("poll_id", pollID),
+			zap.String("user_id", userID),
+			zap.Error(err))
 		return err
 	}
+	if !member {
+		return models.ErrNotChannelMember
+	}
 	return nil
 }
+
+// RefreshPollPost updates the poll post with current results
+func (h *PollHandler) RefreshPollPost(pollID string) {
+	poll, err := h.s.GetPollResult(pollID)
+	if err != nil {
+		h.l.Warn("failed to get poll for post update",
+			zap.String("poll_id", pollID),
+			zap.Error(err))
+		return
+	}
+	h.updatePollPost(poll)
+}
+
+func (h *PollHandler) updatePollPost(poll *models.Poll) {
+	if poll.PostID == "" {
+		return
+	}
+	message := pollMessage(poll)
+	if _, _, err := h.client.PatchPost(poll.PostID, &model.PostPatch{Message: &message}); err != nil {
+		h.l.Warn("failed to update poll post",
+			zap.String("poll_id", poll.ID),
+			zap.String("post_id", poll.PostID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// removedTTL is how long the reaction removed by the bot is remembered to skip its removal event
+const removedTTL = time.Minute
+
+// optionEmojis are the reactions of options in reaction polls, option N gets optionEmojis[N-1]
+var optionEmojis = []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "keycap_ten"}
+
+// addReactions adds one reaction per option to the poll post, so users only have to click them
+func (h *PollHandler) addReactions(poll *models.Poll) {
+	for _, option := range poll.Options {
+		if option.ID < 1 || option.ID > len(optionEmojis) {
+			continue
+		}
+		reaction := &model.Reaction{UserId: h.botID, PostId: poll.PostID, EmojiName: optionEmojis[option.ID-1]}
+		if _, _, err := h.client.SaveReaction(reaction); err != nil {
+			h.l.Warn("failed to add option reaction",
+				zap.String("poll_id", poll.ID),
+				zap.String("emoji", reaction.EmojiName),
+				zap.Error(err))
+		}
+	}
+}
+
+// HandleReaction turns reactions on reaction poll posts into votes, reactions which
+// can not be counted as votes are removed
+func (h *PollHandler) HandleReaction(event *model.WebSocketEvent) {
+	reaction := &model.Reaction{}
+	raw, _ := event.GetData()["reaction"].(string)
+	if err := json.Unmarshal([]byte(raw), reaction); err != nil {
+		h.l.Error("error unmarshalling reaction", zap.Error(err))
+		return
+	}
+	if reaction.UserId == h.botID {
+		return
+	}
+	choiceID := optionChoice(reaction.EmojiName)
+	if choiceID == "" {
+		return
+	}
+	poll, err := h.pollByPost(reaction.PostId)
+	if err != nil || !poll.Settings.Reactions {
+		return
+	}
+	switch event.EventType() {
+	case model.WebsocketEventReactionAdded:
+		if err = h.Vote(poll.ID, choiceID, reaction.UserId); err != nil {
+			h.removeReaction(reaction)
+		}
+	case model.WebsocketEventReactionRemoved:
+		if h.removedByBot(reaction) {
+			return
+		}
+		if err = h.Unvote(poll.ID, choiceID, reaction.UserId); err != nil && !errors.Is(err, models.ErrVoteNotFound) {
+			h.l.Warn("failed to unvote by reaction",
+				zap.String("poll_id", poll.ID),
+				zap.String("user_id", reaction.UserId),
+				zap.Error(err))
+		}
+	}
+}
+
+func (h *PollHandler) Unvote(pollID, choiceID, userID string) error {
+	if err := h.s.Unvote(pollID, choiceID, userID); err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound), errors.Is(err, models.ErrOptionIsNotFound),
+			errors.Is(err, models.ErrVoteNotFound), errors.Is(err, models.ErrPollIsEnd):
+			return err
+		default:
+			h.l.Error("failed to unvote",
+				zap.String("poll_id", pollID),
+				zap.String("choice_id", choiceID),
+				zap.Error(err))
+			return fmt.Errorf("handler: failed to unvote: %w", err)
+		}
+	}
+	h.l.Info("unvoted successfully",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID),
+		zap.String("choice_id", choiceID))
+	h.RefreshPollPost(pollID)
+	return nil
+}
+
+// pollByPost returns the poll of the post, ids of poll posts are cached
+func (h *PollHandler) pollByPost(postID string) (*models.Poll, error) {
+	if pollID, ok := h.pollPosts.Load(postID); ok {
+		return h.s.GetPollResult(pollID.(string))
+	}
+	poll, err := h.s.PollByPost(postID)
+	if err != nil {
+		return nil, err
+	}
+	h.pollPosts.Store(postID, poll.ID)
+	return poll, nil
+}
+
+func (h *PollHandler) removeReaction(reaction *model.Reaction) {
+	now := time.Now()
+	h.removedReactions.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(time.Time)) > removedTTL {
+			h.removedReactions.Delete(key)
+		}
+		return true
+	})
+	h.removedReactions.Store(reactionKey(reaction), now)
+	if _, err := h.client.DeleteReaction(reaction); err != nil {
+		h.removedReactions.Delete(reactionKey(reaction))
+		h.l.Warn("failed to remove reaction",
+			zap.String("post_id", reaction.PostId),
+			zap.String("user_id", reaction.UserId),
+			zap.Error(err))
+	}
+}
+
+func (h *PollHandler) removedByBot(reaction *model.Reaction) bool {
+	_, ok := h.removedReactions.LoadAndDelete(reactionKey(reaction))
+	return ok
+}
+
+func reactionKey(reaction *model.Reaction) string {
+	return reaction.PostId + ":" + reaction.UserId + ":" + reaction.EmojiName
+}
+
+// optionChoice returns the choice id of the option emoji, empty for other emojis
+func optionChoice(emoji string) string {
+	for i, name := range optionEmojis {
+		if name == emoji {
+			return strconv.Itoa(i + 1)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+const membersPerPage = 200
+
+// Remind sends direct messages to channel members who have not voted yet, returns number of sent reminders
+func (h *PollHandler) Remind(pollID, userID, channelID string) (int, error) {
+	h.l.Debug("data for reminding",
+		zap.String("poll_id", pollID),
+		zap.String("user_id", userID))
+	listMembers := func(pollChannelID string) ([]string, error) {
+		if pollChannelID == "" {
+			pollChannelID = channelID
+		}
+		if pollChannelID == "" {
+			return nil, errNoPollChannel
+		}
+		return h.listMembers(pollChannelID)
+	}
+	poll, targets, err := h.s.Remind(pollID, userID, listMembers)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			h.l.Warn("poll not found", zap.String("poll_id", pollID))
+			return 0, err
+		case errors.Is(err, models.ErrUserNotOwner):
+			h.l.Warn("user is not owner of poll",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			return 0, err
+		case errors.Is(err, models.ErrPollIsEnd):
+			h.l.Warn("poll is ended", zap.String("poll_id", pollID))
+			return 0, err
+		case errors.Is(err, errNoPollChannel):
+			h.l.Warn("poll has no channel to remind", zap.String("poll_id", pollID))
+			return 0, err
+		default:
+			h.l.Error("failed to remind",
+				zap.String("poll_id", pollID),
+				zap.Error(err))
+			return 0, fmt.Errorf("handler: failed to remind: %w", err)
+		}
+	}
+	sent := h.sendReminders(poll, targets)
+	h.l.Info("successfully sent reminders",
+		zap.String("poll_id", pollID),
+		zap.Int("sent", sent))
+	return sent, nil
+}
+
+// RunScheduler sends automatic reminders and ends polls by their deadlines until ctx is done
+func (h *PollHandler) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.schedule(now)
+		}
+	}
+}
+
+func (h *PollHandler) schedule(now time.Time) {
+	remind, expired, err := h.s.DuePolls(now)
+	if err != nil {
+		h.l.Error("failed to get due polls", zap.Error(err))
+		return
+	}
+	for _, poll := range remind {
+		targets, err := h.s.AutoRemind(poll, h.listMembers)
+		if err != nil {
+			h.l.Error("failed to remind automatically",
+				zap.String("poll_id", poll.ID),
+				zap.Error(err))
+			continue
+		}
+		sent := h.sendReminders(poll, targets)
+		h.l.Info("sent automatic reminders",
+			zap.String("poll_id", poll.ID),
+			zap.Int("sent", sent))
+		if sent == 0 && len(targets) > 0 {
+			// nothing was delivered, the poll is reminded again on the next tick
+			continue
+		}
+		if err = h.s.MarkPollReminded(poll.ID); err != nil {
+			h.l.Error("failed to mark poll as reminded",
+				zap.String("poll_id", poll.ID),
+				zap.Error(err))
+		}
+	}
+	for _, expiredPoll := range expired {
+		poll, decision, err := h.s.ClosePoll(expiredPoll.ID, h.CountMembers)
+		if errors.Is(err, models.ErrPollAlreadyEnded) {
+			h.l.Debug("poll was ended before its deadline", zap.String("poll_id", expiredPoll.ID))
+			continue
+		}
+		if err != nil {
+			h.l.Error("failed to close poll by deadline",
+				zap.String("poll_id", expiredPoll.ID),
+				zap.Error(err))
+			continue
+		}
+		h.updatePollPost(poll)
+		if err = h.sendThread(finalMessage(poll, decision, true), poll); err != nil {
+			h.l.Error("failed sending poll result", zap.Error(err))
+			continue
+		}
+		h.l.Info("closed poll by deadline", zap.String("poll_id", poll.ID))
+	}
+	if purged, err := h.s.PurgeDeleted(now); err == nil && purged > 0 {
+		h.l.Info("purged deleted polls", zap.Int("purged", purged))
+	}
+	if purged, err := h.s.PurgeAudit(now); err == nil && purged > 0 {
+		h.l.Info("purged expired audit entries", zap.Int("deleted", purged))
+	}
+}
+
+func (h *PollHandler) sendReminders(poll *models.Poll, targets []string) int {
+	message := fmt.Sprintf("you have not voted in the poll **%s** yet: %s", poll.Question, h.pollLink(poll))
+	if deadline := poll.Settings.Deadline; deadline != nil {
+		message += fmt.Sprintf("\nthe poll ends at %s", deadline.Format(time.RFC1123))
+	}
+	message += "\nsend `/poll remind off` to stop reminders"
+	sent := 0
+	for _, userID := range targets {
+		if err := h.sendDirect(message, userID); err != nil {
+			h.l.Error("failed sending reminder",
+				zap.String("poll_id", poll.ID),
+				zap.String("user_id", userID),
+				zap.Error(err))
+			continue
+		}
+		if err := h.s.MarkReminded(userID, time.Now()); err != nil {
+			h.l.Warn("failed to save reminder cooldown",
+				zap.String("user_id", userID),
+				zap.Error(err))
+		}
+		sent++
+	}
+	if sent > 0 && poll.ChannelID != "" && poll.ThreadID() != "" {
+		if err := h.sendThread(fmt.Sprintf("reminded %d members who have not voted yet", sent), poll); err != nil {
+			h.l.Warn("failed to post reminder note to the poll thread",
+				zap.String("poll_id", poll.ID),
+				zap.Error(err))
+		}
+	}
+	return sent
+}
+
+// pollLink returns permalink to the poll post or the vote command for polls without it
+func (h *PollHandler) pollLink(poll *models.Poll) string {
+	if poll.PostID == "" {
+		return fmt.Sprintf("`/poll vote %s choice_id`", poll.ID)
+	}
+	return fmt.Sprintf("%s/_redirect/pl/%s", h.client.URL, poll.PostID)
+}
+
+func (h *PollHandler) sendDirect(message, userID string) error {
+	channel, _, err := h.client.CreateDirectChannel(h.botID, userID)
+	if err != nil {
+		return err
+	}
+	return h.SendMsg(message, channel.Id)
+}
+
+// listMembers returns ids of channel members who are active users, not bots
+func (h *PollHandler) listMembers(channelID string) ([]string, error) {
+	var ids []string
+	for page := 0; ; page++ {
+		members, _, err := h.client.GetChannelMembers(channelID, page, membersPerPage, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			ids = append(ids, member.UserId)
+		}
+		if len(members) < membersPerPage {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	users, _, err := h.client.GetUsersByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	humans := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.IsBot || user.DeleteAt != 0 || user.Id == h.botID {
+			continue
+		}
+		humans = append(humans, user.Id)
+	}
+	return humans, nil
+}
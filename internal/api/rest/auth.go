@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// auth authenticates request by bearer token and passes the user id of the token in the context
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		userID := ""
+		for known, id := range s.cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+				userID = id
+			}
+		}
+		if userID == "" {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, userID)))
+	})
+}
+
+func userID(r *http.Request) string {
+	id, _ := r.Context().Value(ctxKey{}).(string)
+	return id
+}
@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const checkTimeout = 5 * time.Second
+
+// Check reports the state of the dependency, nil error means it is healthy
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime,omitempty"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// AddCheck registers dependency check used by readiness probe
+func (s *Server) AddCheck(name string, check Check) {
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{
+		Status: "ok",
+		Uptime: time.Since(s.started).Round(time.Second).String(),
+	})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	results := make(map[string]checkResult, len(s.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range s.checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			res := runCheck(ctx, c.check)
+			mu.Lock()
+			results[c.name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for name, res := range results {
+		if res.Status != "ok" {
+			status, code = "fail", http.StatusServiceUnavailable
+			s.l.Warn("readiness check failed",
+				zap.String("check", name),
+				zap.String("error", res.Error))
+		}
+	}
+	writeJSON(w, code, healthResponse{Status: status, Checks: results})
+}
+
+// runCheck runs the check and gives up when ctx is done, since not every client supports context
+func runCheck(ctx context.Context, check Check) checkResult {
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := checkResult{Status: "ok", Duration: time.Since(start).String()}
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+	}
+	return res
+}
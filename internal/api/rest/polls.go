@@ -0,0 +1,264 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notifier publishes poll changes made through the api to Mattermost
+type Notifier interface {
+	Announce(poll *models.Poll) error
+	AnnounceEnd(poll *models.Poll, decision *models.Decision)
+	RefreshPollPost(pollID string)
+	CountMembers(channelID string) (int, error)
+	IsChannelMember(channelID, userID string) (bool, error)
+}
+
+type createPollRequest struct {
+	Question      string           `json:"question"`
+	Options       []string         `json:"options"`
+	ChannelID     string           `json:"channel_id"`
+	Quorum        int              `json:"quorum"`
+	QuorumPercent int              `json:"quorum_percent"`
+	Threshold     *models.Fraction `json:"threshold"`
+	Deadline      *time.Time       `json:"deadline"`
+	RemindBefore  string           `json:"remind_before"`
+	Anonymous     bool             `json:"anonymous"`
+	MaxChoices    int              `json:"max_choices"`
+	Reactions     bool             `json:"reactions"`
+}
+
+type voteRequest struct {
+	ChoiceID int `json:"choice_id"`
+}
+
+type endPollResponse struct {
+	Poll     *models.Poll     `json:"poll"`
+	Decision *models.Decision `json:"decision,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) createPoll(w http.ResponseWriter, r *http.Request) {
+	var req createPollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	settings := models.Settings{
+		Quorum:        req.Quorum,
+		QuorumPercent: req.QuorumPercent,
+		Threshold:     req.Threshold,
+		Deadline:      req.Deadline,
+		Anonymous:     req.Anonymous,
+		MaxChoices:    req.MaxChoices,
+		Reactions:     req.Reactions,
+	}
+	if req.RemindBefore != "" {
+		before, err := time.ParseDuration(req.RemindBefore)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, models.ErrInvalidRemind.Error())
+			return
+		}
+		settings.RemindBefore = before
+	}
+	if err := s.checkChannel(r, req.ChannelID); err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	poll, err := s.s.CreatePoll(req.Question, userID(r), req.ChannelID, req.Options, settings)
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	if poll.ChannelID != "" {
+		if err = s.notifier.Announce(poll); err != nil {
+			s.l.Warn("failed to announce poll created with api",
+				zap.String("poll_id", poll.ID),
+				zap.Error(err))
+		}
+	}
+	s.l.Info("poll created with api",
+		zap.String("poll_id", poll.ID),
+		zap.String("user_id", userID(r)))
+	writeJSON(w, http.StatusCreated, poll)
+}
+
+// listPolls returns only polls from the channels the caller is a member of
+func (s *Server) listPolls(w http.ResponseWriter, r *http.Request) {
+	channelID := r.URL.Query().Get("channel_id")
+	if err := s.checkChannel(r, channelID); err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	polls, err := s.s.ListPolls(channelID, r.URL.Query().Get("creator_id"))
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	visible := make([]*models.Poll, 0, len(polls))
+	member := make(map[string]bool)
+	for _, poll := range polls {
+		ok, checked := member[poll.ChannelID]
+		if !checked {
+			if err = s.checkChannel(r, poll.ChannelID); err != nil && !errors.Is(err, models.ErrNotChannelMember) {
+				s.writeServiceError(w, err)
+				return
+			}
+			ok = err == nil
+			member[poll.ChannelID] = ok
+		}
+		if ok {
+			visible = append(visible, poll)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+func (s *Server) getPoll(w http.ResponseWriter, r *http.Request) {
+	poll, err := s.visiblePoll(r)
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, poll)
+}
+
+func (s *Server) vote(w http.ResponseWriter, r *http.Request) {
+	var req voteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	poll, err := s.visiblePoll(r)
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	pollID := poll.ID
+	if err = s.s.Vote(pollID, strconv.Itoa(req.ChoiceID), userID(r)); err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	s.notifier.RefreshPollPost(pollID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) endPoll(w http.ResponseWriter, r *http.Request) {
+	poll, decision, err := s.s.EndPoll(r.PathValue("id"), userID(r), s.notifier.CountMembers)
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	s.notifier.AnnounceEnd(poll, decision)
+	writeJSON(w, http.StatusOK, endPollResponse{Poll: poll, Decision: decision})
+}
+
+func (s *Server) deletePoll(w http.ResponseWriter, r *http.Request) {
+	if err := s.s.DeletePoll(r.PathValue("id"), userID(r)); err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// undeletePoll restores the deleted poll within the undelete window and refreshes its post
+func (s *Server) undeletePoll(w http.ResponseWriter, r *http.Request) {
+	poll, err := s.s.UndeletePoll(r.PathValue("id"), userID(r))
+	if err != nil {
+		s.writeServiceError(w, err)
+		return
+	}
+	s.notifier.RefreshPollPost(poll.ID)
+	writeJSON(w, http.StatusOK, poll)
+}
+
+func (s *Server) pinPoll(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.s.PinPoll(r.PathValue("id"), userID(r), false, pinned); err != nil {
+			s.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkChannel returns models.ErrNotChannelMember if the caller is not a member of the channel,
+// polls without a channel are open to every caller
+func (s *Server) checkChannel(r *http.Request, channelID string) error {
+	if channelID == "" {
+		return nil
+	}
+	member, err := s.notifier.IsChannelMember(channelID, userID(r))
+	if err != nil {
+		return fmt.Errorf("rest: failed to check channel membership: %w", err)
+	}
+	if !member {
+		return models.ErrNotChannelMember
+	}
+	return nil
+}
+
+// visiblePoll returns the poll from the path if the caller can see its channel
+func (s *Server) visiblePoll(r *http.Request) (*models.Poll, error) {
+	poll, err := s.s.GetPollResult(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkChannel(r, poll.ChannelID); err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// writeServiceError maps models errors to http status codes
+func (s *Server) writeServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrPollNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, models.ErrUserNotOwner),
+		errors.Is(err, models.ErrNotChannelMember):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, models.ErrVoteAlreadyExists),
+		errors.Is(err, models.ErrTooManyChoices),
+		errors.Is(err, models.ErrVoteNotFound),
+		errors.Is(err, models.ErrPollIsEnd),
+		errors.Is(err, models.ErrPollAlreadyEnded),
+		errors.Is(err, models.ErrPollNotDeleted),
+		errors.Is(err, models.ErrUndeleteExpired):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, models.ErrOptionIsNotFound),
+		errors.Is(err, models.ErrOptionIsEmpty),
+		errors.Is(err, models.ErrNotEnoughOptions),
+		errors.Is(err, models.ErrQuestionIsEmpty),
+		errors.Is(err, models.ErrInvalidQuorum),
+		errors.Is(err, models.ErrInvalidThreshold),
+		errors.Is(err, models.ErrInvalidDeadline),
+		errors.Is(err, models.ErrInvalidRemind),
+		errors.Is(err, models.ErrInvalidMaxChoices),
+		errors.Is(err, models.ErrInvalidReactions),
+		errors.Is(err, models.ErrAnonymousReactions):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.l.Error("api request failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
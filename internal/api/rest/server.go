@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"github.com/jaam8/mattermost_bot/internal/service"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+type Config struct {
+	// Tokens: api token to id of the Mattermost user the requests are made on behalf of
+	Tokens map[string]string `yaml:"API_TOKENS" env:"API_TOKENS" env-separator:","`
+}
+
+type Server struct {
+	s        *service.PollService
+	notifier Notifier
+	l        *zap.Logger
+	cfg      Config
+	mux      *http.ServeMux
+	srv      *http.Server
+	checks   []namedCheck
+	started  time.Time
+}
+
+func New(port string, s *service.PollService, notifier Notifier, l *zap.Logger, cfg Config) *Server {
+	server := &Server{
+		s:        s,
+		notifier: notifier,
+		l:        l,
+		cfg:      cfg,
+		mux:      http.NewServeMux(),
+		started:  time.Now(),
+	}
+	server.srv = &http.Server{
+		Addr:              ":" + port,
+		Handler:           server.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	server.routes()
+	return server
+}
+
+// Run serves http requests until ctx is done
+func (s *Server) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		s.l.Info("http server is listening", zap.String("addr", s.srv.Addr))
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return s.srv.Shutdown(shutdownCtx)
+	}
+}
+
+// Handle registers additional route on the server
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+	s.mux.HandleFunc("GET /readyz", s.readyz)
+	s.mux.Handle("POST /api/v1/polls", s.auth(s.createPoll))
+	s.mux.Handle("GET /api/v1/polls", s.auth(s.listPolls))
+	s.mux.Handle("GET /api/v1/polls/{id}", s.auth(s.getPoll))
+	s.mux.Handle("POST /api/v1/polls/{id}/votes", s.auth(s.vote))
+	s.mux.Handle("POST /api/v1/polls/{id}/end", s.auth(s.endPoll))
+	s.mux.Handle("DELETE /api/v1/polls/{id}", s.auth(s.deletePoll))
+	s.mux.Handle("POST /api/v1/polls/{id}/undelete", s.auth(s.undeletePoll))
+	s.mux.Handle("PUT /api/v1/polls/{id}/pin", s.auth(s.pinPoll(true)))
+	s.mux.Handle("DELETE /api/v1/polls/{id}/pin", s.auth(s.pinPoll(false)))
+}
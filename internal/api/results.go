@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const progressWidth = 10
+
+// partial blocks for the last cell of progress bar, index is number of eighths
+var partialBlocks = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// results renders poll results for result requests, live poll post updates and final announcements
+type results struct {
+	poll  *models.Poll
+	total int
+	// rows: options sorted by votes
+	rows    []resultRow
+	winners []resultRow
+}
+
+type resultRow struct {
+	option models.Option
+	votes  int
+}
+
+func newResults(poll *models.Poll) *results {
+	r := &results{poll: poll}
+	for _, option := range poll.Options {
+		count := poll.Votes[strconv.Itoa(option.ID)]
+		r.total += count
+		r.rows = append(r.rows, resultRow{option: option, votes: count})
+	}
+	sort.SliceStable(r.rows, func(i, j int) bool {
+		return r.rows[i].votes > r.rows[j].votes
+	})
+	for _, row := range r.rows {
+		if row.votes == 0 || row.votes < r.rows[0].votes {
+			break
+		}
+		r.winners = append(r.winners, row)
+	}
+	return r
+}
+
+func (r *results) status() string {
+	if !r.poll.IsActive {
+		return "**Status**: ended\n"
+	}
+	if deadline := r.poll.Settings.Deadline; deadline != nil {
+		return fmt.Sprintf("**Status**: open until %s\n", deadline.Format(time.RFC1123))
+	}
+	return "**Status**: open\n"
+}
+
+// bars formats options sorted by votes with progress bars and percentages
+func (r *results) bars() string {
+	message := ""
+	for _, row := range r.rows {
+		mark := ""
+		if r.isWinner(row) {
+			mark = " 🏆"
+		}
+		message += fmt.Sprintf("  [%d] `%s` %s (**%d**) *%s*%s\n", row.option.ID,
+			progressBar(row.votes, r.total), r.percent(row.votes), row.votes, row.option.Text, mark)
+	}
+	return message
+}
+
+// summary formats total votes and the winner or the tie
+func (r *results) summary() string {
+	message := fmt.Sprintf("**Total votes**: %d\n", r.total)
+	leader := "Winner"
+	if r.poll.IsActive {
+		leader = "Leading"
+	}
+	switch len(r.winners) {
+	case 0:
+		message += "no votes yet\n"
+	case 1:
+		winner := r.winners[0]
+		message += fmt.Sprintf("**%s**: [%d] *%s* with %d votes (%s)\n", leader,
+			winner.option.ID, winner.option.Text, winner.votes, r.percent(winner.votes))
+	default:
+		tied := make([]string, 0, len(r.winners))
+		for _, row := range r.winners {
+			tied = append(tied, fmt.Sprintf("[%d] *%s*", row.option.ID, row.option.Text))
+		}
+		message += fmt.Sprintf("**Tie**: %s with %d votes each\n",
+			strings.Join(tied, ", "), r.winners[0].votes)
+	}
+	return message
+}
+
+func (r *results) String() string {
+	return r.status() + r.bars() + r.summary()
+}
+
+func (r *results) isWinner(row resultRow) bool {
+	for _, winner := range r.winners {
+		if winner.option.ID == row.option.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *results) percent(count int) string {
+	if r.total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%d%%", (count*100+r.total/2)/r.total)
+}
+
+// progressBar draws unicode bar with eighth-block precision
+func progressBar(part, total int) string {
+	if total == 0 {
+		return strings.Repeat("░", progressWidth)
+	}
+	eighths := part * progressWidth * 8 / total
+	full, rest := eighths/8, eighths%8
+	bar := strings.Repeat("█", full) + partialBlocks[rest]
+	cells := full
+	if rest > 0 {
+		cells++
+	}
+	return bar + strings.Repeat("░", progressWidth-cells)
+}
+
+// pollMessage formats the poll post: options to vote for, rules and live results
+func pollMessage(poll *models.Poll) string {
+	message := fmt.Sprintf("**Poll ID**: %s\n**Question**: %s\n**Options**:\n", poll.ID, poll.Question)
+	for _, option := range poll.Options {
+		message += fmt.Sprintf("  [%d] *%s*\n", option.ID, option.Text)
+	}
+	if choices := poll.Settings.Choices(); choices > 1 {
+		message += fmt.Sprintf("**Choose** up to %d options\n", choices)
+	}
+	if poll.Settings.IsDecision() {
+		message += fmt.Sprintf("**Rules**: %s\n", formatRules(poll.Settings))
+	}
+	return message + "\n" + newResults(poll).String()
+}
+
+// finalMessage formats the announcement of the ended poll
+func finalMessage(poll *models.Poll, decision *models.Decision, byDeadline bool) string {
+	message := fmt.Sprintf("**Poll** %s is ended\n", poll.ID)
+	if byDeadline {
+		message = fmt.Sprintf("**Poll** %s is ended by deadline\n", poll.ID)
+	}
+	res := newResults(poll)
+	message += fmt.Sprintf("**Question**: %s\n", poll.Question) + res.bars() + res.summary()
+	if decision != nil {
+		message += formatDecision(poll, decision)
+	}
+	return message
+}
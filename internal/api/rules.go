@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnknownFlag = errors.New("unknown flag")
+
+// parseSettings parses create flags: --quorum N|N%, --threshold N/M|N%, --deadline, --remind, --anonymous, --max-choices and --reactions
+func parseSettings(flags map[string]string) (models.Settings, error) {
+	var settings models.Settings
+	_, settings.Anonymous = flags["anonymous"]
+	_, settings.Reactions = flags["reactions"]
+	if value, ok := flags["quorum"]; ok {
+		if strings.HasSuffix(value, "%") {
+			percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || percent < 1 || percent > 100 {
+				return settings, models.ErrInvalidQuorum
+			}
+			settings.QuorumPercent = percent
+		} else {
+			quorum, err := strconv.Atoi(value)
+			if err != nil || quorum < 1 {
+				return settings, models.ErrInvalidQuorum
+			}
+			settings.Quorum = quorum
+		}
+	}
+	if value, ok := flags["threshold"]; ok {
+		threshold, err := parseThreshold(value)
+		if err != nil {
+			return settings, err
+		}
+		settings.Threshold = threshold
+	}
+	if value, ok := flags["deadline"]; ok {
+		deadline, err := parseDeadline(value)
+		if err != nil {
+			return settings, err
+		}
+		settings.Deadline = &deadline
+	}
+	if value, ok := flags["remind"]; ok {
+		before, err := time.ParseDuration(value)
+		if err != nil || before <= 0 {
+			return settings, models.ErrInvalidRemind
+		}
+		settings.RemindBefore = before
+	}
+	if value, ok := flags["max-choices"]; ok {
+		maxChoices, err := strconv.Atoi(value)
+		if err != nil || maxChoices < 1 {
+			return settings, models.ErrInvalidMaxChoices
+		}
+		settings.MaxChoices = maxChoices
+	}
+	return settings, nil
+}
+
+func parseThreshold(value string) (*models.Fraction, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 1 || percent > 100 {
+			return nil, models.ErrInvalidThreshold
+		}
+		return &models.Fraction{Num: percent, Den: 100}, nil
+	}
+	num, den, ok := strings.Cut(value, "/")
+	if !ok {
+		return nil, models.ErrInvalidThreshold
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return nil, models.ErrInvalidThreshold
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil || n < 1 || d < 1 || n > d {
+		return nil, models.ErrInvalidThreshold
+	}
+	return &models.Fraction{Num: n, Den: d}, nil
+}
+
+// parseDeadline accepts a duration from now or RFC3339 time
+func parseDeadline(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(d).Truncate(time.Second), nil
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, models.ErrInvalidDeadline
+	}
+	return deadline, nil
+}
+
+func formatRules(settings models.Settings) string {
+	var rules []string
+	if settings.Quorum > 0 {
+		rules = append(rules, fmt.Sprintf("quorum %d votes", settings.Quorum))
+	}
+	if settings.QuorumPercent > 0 {
+		rules = append(rules, fmt.Sprintf("quorum %d%% of channel", settings.QuorumPercent))
+	}
+	if t := settings.Threshold; t != nil {
+		if t.Den == 100 {
+			rules = append(rules, fmt.Sprintf("threshold %d%%", t.Num))
+		} else {
+			rules = append(rules, fmt.Sprintf("threshold %d/%d", t.Num, t.Den))
+		}
+	}
+	return strings.Join(rules, ", ")
+}
+
+func formatDecision(poll *models.Poll, decision *models.Decision) string {
+	settings := poll.Settings
+	switch decision.Outcome {
+	case models.OutcomePassed:
+		return fmt.Sprintf("**Result**: passed, *%s* won with %d of %d votes (%s)",
+			decision.Winner.Text, poll.Votes[strconv.Itoa(decision.Winner.ID)],
+			decision.Turnout, formatRules(settings))
+	case models.OutcomeNoQuorum:
+		return fmt.Sprintf("**Result**: no quorum, %d votes of %d members (%s)",
+			decision.Turnout, decision.Members, formatRules(settings))
+	default:
+		return fmt.Sprintf("**Result**: failed, %d votes (%s)",
+			decision.Turnout, formatRules(settings))
+	}
+}
+
+// formatDuration formats the duration without zero minutes and seconds, 24h instead of 24h0m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
@@ -2,17 +2,37 @@ package config
 
 import (
 	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/jaam8/mattermost_bot/internal/api"
+	"github.com/jaam8/mattermost_bot/internal/api/rest"
+	"github.com/jaam8/mattermost_bot/internal/service"
+	"github.com/jaam8/mattermost_bot/pkg/postgres"
+	"github.com/jaam8/mattermost_bot/pkg/sqlite"
 	"github.com/jaam8/mattermost_bot/pkg/tarantool"
+	"github.com/jaam8/mattermost_bot/pkg/workerpool"
 	"github.com/joho/godotenv"
+	"time"
 )
 
 type Config struct {
-	RestPort  string           `yaml:"REST_PORT"  env:"REST_PORT" env-default:"8080"`
-	BotToken  string           `yaml:"BOT_TOKEN"  env:"BOT_TOKEN"`
-	MmURL     string           `yaml:"MM_URL"     env:"MM_URL"`
-	MmWsURL   string           `yaml:"MM_WS_URL"  env:"MM_WS_URL"`
-	LogLevel  string           `yaml:"LOG_LEVEL"  env:"LOG_LEVEL" env-default:"debug"`
-	Tarantool tarantool.Config `yaml:"TARANTOOL"  env:"TARANTOOL"`
+	RestPort          string                  `yaml:"REST_PORT"          env:"REST_PORT" env-default:"8080"`
+	BotToken          string                  `yaml:"BOT_TOKEN"          env:"BOT_TOKEN"`
+	MmURL             string                  `yaml:"MM_URL"             env:"MM_URL"`
+	MmWsURL           string                  `yaml:"MM_WS_URL"          env:"MM_WS_URL"`
+	LogLevel          string                  `yaml:"LOG_LEVEL"          env:"LOG_LEVEL" env-default:"debug"`
+	SchedulerInterval time.Duration           `yaml:"SCHEDULER_INTERVAL" env:"SCHEDULER_INTERVAL" env-default:"30s"`
+	Storage           string                  `yaml:"STORAGE"            env:"STORAGE" env-default:"tarantool"`
+	Tarantool         tarantool.Config        `yaml:"TARANTOOL"          env:"TARANTOOL"`
+	Postgres          postgres.Config         `yaml:"POSTGRES"           env:"POSTGRES"`
+	SQLite            sqlite.Config           `yaml:"SQLITE"             env:"SQLITE"`
+	Service           service.Config          `yaml:"SERVICE"            env:"SERVICE"`
+	Webhooks          service.WebhookConfig   `yaml:"WEBHOOKS"           env:"WEBHOOKS"`
+	RateLimit         service.RateLimitConfig `yaml:"RATE_LIMIT"       env:"RATE_LIMIT"`
+	API               rest.Config             `yaml:"API"                env:"API"`
+	Bot               api.Config              `yaml:"BOT"                env:"BOT"`
+	Websocket         api.ListenerConfig      `yaml:"WEBSOCKET"          env:"WEBSOCKET"`
+	Workers           workerpool.Config       `yaml:"WORKERS"            env:"WORKERS"`
+	ShutdownTimeout   time.Duration           `yaml:"SHUTDOWN_TIMEOUT"   env:"SHUTDOWN_TIMEOUT" env-default:"30s"`
+	Integrity         service.IntegrityConfig `yaml:"INTEGRITY"          env:"INTEGRITY"`
 }
 
 func New() (*Config, error) {
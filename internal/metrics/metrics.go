@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+const namespace = "mattermost_bot"
+
+var (
+	commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Number of /poll subcommands by outcome.",
+	}, []string{"command", "outcome"})
+
+	tarantoolDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tarantool_request_duration_seconds",
+		Help:      "Latency of Tarantool requests.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "space", "status"})
+
+	mattermostDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mattermost_request_duration_seconds",
+		Help:      "Latency of Mattermost API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "status_code"})
+
+	websocketReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_reconnects_total",
+		Help:      "Number of Mattermost websocket reconnects.",
+	})
+)
+
+// ObserveCommand counts the subcommand with outcome "ok", "usage" or the models error name
+func ObserveCommand(command string, err error, usage bool) {
+	outcome := "ok"
+	switch {
+	case usage:
+		outcome = "usage"
+	case err != nil:
+		outcome = ErrorLabel(err)
+	}
+	commands.WithLabelValues(command, outcome).Inc()
+}
+
+// ObserveTarantool records the Tarantool request latency
+func ObserveTarantool(operation, space string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	tarantoolDuration.WithLabelValues(operation, space, status).Observe(time.Since(start).Seconds())
+}
+
+// ObserveMattermost records the Mattermost API request latency, statusCode is 0 if there was no response
+func ObserveMattermost(method string, start time.Time, statusCode int) {
+	mattermostDuration.WithLabelValues(method, strconv.Itoa(statusCode)).Observe(time.Since(start).Seconds())
+}
+
+func WebsocketReconnected() {
+	websocketReconnects.Inc()
+}
+
+// RegisterActivePolls exposes the number of active polls, counted on every scrape
+func RegisterActivePolls(count func() (int, error)) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_polls",
+		Help:      "Number of active polls.",
+	}, func() float64 {
+		n, err := count()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	})
+}
+
+// ErrorLabel returns metric label for the models error
+func ErrorLabel(err error) string {
+	switch {
+	case errors.Is(err, models.ErrPollIsEnd):
+		return "poll_is_end"
+	case errors.Is(err, models.ErrPollNotFound):
+		return "poll_not_found"
+	case errors.Is(err, models.ErrFailedToProcessData):
+		return "failed_to_process_data"
+	case errors.Is(err, models.ErrOptionIsEmpty):
+		return "option_is_empty"
+	case errors.Is(err, models.ErrNotEnoughOptions):
+		return "not_enough_options"
+	case errors.Is(err, models.ErrQuestionIsEmpty):
+		return "question_is_empty"
+	case errors.Is(err, models.ErrOptionIsNotFound):
+		return "option_not_found"
+	case errors.Is(err, models.ErrVoteAlreadyExists):
+		return "vote_already_exists"
+	case errors.Is(err, models.ErrPollAlreadyEnded):
+		return "poll_already_ended"
+	case errors.Is(err, models.ErrUserNotOwner):
+		return "user_not_owner"
+	case errors.Is(err, models.ErrInvalidQuorum):
+		return "invalid_quorum"
+	case errors.Is(err, models.ErrInvalidThreshold):
+		return "invalid_threshold"
+	case errors.Is(err, models.ErrInvalidDeadline):
+		return "invalid_deadline"
+	case errors.Is(err, models.ErrInvalidRemind):
+		return "invalid_remind"
+	case errors.Is(err, models.ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, models.ErrInvalidMaxChoices):
+		return "invalid_max_choices"
+	case errors.Is(err, models.ErrTooManyChoices):
+		return "too_many_choices"
+	case errors.Is(err, models.ErrVoteNotFound):
+		return "vote_not_found"
+	case errors.Is(err, models.ErrInvalidReactions):
+		return "invalid_reactions"
+	case errors.Is(err, models.ErrAnonymousReactions):
+		return "anonymous_reactions"
+	case errors.Is(err, models.ErrPollNotDeleted):
+		return "poll_not_deleted"
+	case errors.Is(err, models.ErrUndeleteExpired):
+		return "undelete_expired"
+	default:
+		return "internal"
+	}
+}
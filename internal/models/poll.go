@@ -1,6 +1,9 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrPollIsEnd           = errors.New("poll is end")
@@ -13,6 +16,19 @@ var (
 	ErrVoteAlreadyExists   = errors.New("your vote already written")
 	ErrPollAlreadyEnded    = errors.New("poll already ended")
 	ErrUserNotOwner        = errors.New("you are not the owner of this poll")
+	ErrInvalidQuorum       = errors.New("quorum should be a positive number of votes or a percent from 1% to 100%")
+	ErrInvalidThreshold    = errors.New("threshold should be a fraction like 2/3 or a percent from 1% to 100%")
+	ErrInvalidDeadline     = errors.New("deadline should be a duration like 24h or a time like 2006-01-02T15:04:05Z07:00 in the future")
+	ErrInvalidRemind       = errors.New("reminder should be a positive duration like 1h and requires a deadline")
+	ErrNotChannelMember    = errors.New("you are not a member of the poll channel")
+	ErrRateLimited         = errors.New("slow down, you are sending commands too fast")
+	ErrInvalidMaxChoices   = errors.New("max choices should be from 1 to the number of options")
+	ErrTooManyChoices      = errors.New("you have already chosen the maximum number of options")
+	ErrVoteNotFound        = errors.New("you have not voted for this option")
+	ErrInvalidReactions    = errors.New("reaction voting supports up to 10 options")
+	ErrPollNotDeleted      = errors.New("poll is not deleted")
+	ErrUndeleteExpired     = errors.New("poll was deleted too long ago to restore")
+	ErrAnonymousReactions  = errors.New("anonymous polls can not use reactions, reactions show every voter")
 )
 
 type Poll struct {
@@ -23,8 +39,72 @@ type Poll struct {
 	Votes     map[string]int `json:"votes"`
 	CreatorID string         `json:"creator_id"`
 	IsActive  bool           `json:"is_active"`
+	ChannelID string         `json:"channel_id"`
+	// Settings: stored in tarantool as json string
+	Settings Settings `json:"settings"`
+	// PostID: id of the bot message with the poll
+	PostID string `json:"post_id"`
+	// Reminded: automatic reminder before the deadline is already sent
+	Reminded bool `json:"reminded"`
+	// RootID: root of the poll thread, the thread the poll was created in or the poll post itself
+	RootID string `json:"root_id,omitempty"`
+	// DeletedAt: time the poll was moved to trash, deleted polls are hidden until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// EndedAt: time the poll was ended, retention periods are counted from it
+	EndedAt *time.Time `json:"ended_at,omitempty"`
+	// Pinned: the poll is kept forever regardless of the retention policy
+	Pinned bool `json:"pinned,omitempty"`
+	// Anonymized: voter ids of the poll are replaced by the retention policy
+	Anonymized bool `json:"anonymized,omitempty"`
+}
+
+// ThreadID returns the post results and reminders of the poll are threaded under,
+// polls created before threads were stored fall back to the poll post
+func (p *Poll) ThreadID() string {
+	if p.RootID != "" {
+		return p.RootID
+	}
+	return p.PostID
+}
+
+// Settings are optional poll parameters
+type Settings struct {
+	// Quorum: minimal number of votes for the result to be valid
+	Quorum int `json:"quorum,omitempty"`
+	// QuorumPercent: minimal number of votes in percent of channel members
+	QuorumPercent int `json:"quorum_percent,omitempty"`
+	// Threshold: share of votes the leading option needs to pass
+	Threshold *Fraction `json:"threshold,omitempty"`
+	// Deadline: the poll is ended automatically at this time
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// RemindBefore: non-voters are reminded this long before the deadline
+	RemindBefore time.Duration `json:"remind_before,omitempty"`
+	// Anonymous: ballots of voters are not exported
+	Anonymous bool `json:"anonymous,omitempty"`
+	// MaxChoices: how many options one user can vote for, 0 means one
+	MaxChoices int `json:"max_choices,omitempty"`
+	// Reactions: users vote with emoji reactions on the poll post
+	Reactions bool `json:"reactions,omitempty"`
+}
+
+// Choices returns how many options one user can vote for
+func (s Settings) Choices() int {
+	return max(s.MaxChoices, 1)
+}
+
+// IsDecision reports whether the poll has rules to evaluate when it ends
+func (s Settings) IsDecision() bool {
+	return s.Quorum > 0 || s.QuorumPercent > 0 || s.Threshold != nil
+}
+
+type Fraction struct {
+	Num int `json:"num"`
+	Den int `json:"den"`
 }
 
+// AnonymousVoter prefixes voter ids which replace user ids when the votes are anonymized
+const AnonymousVoter = "anon:"
+
 type Vote struct {
 	PollID   string `json:"poll_id"`
 	UserID   string `json:"user_id"`
@@ -35,3 +115,116 @@ type Option struct {
 	ID   int    `json:"id"`
 	Text string `json:"text"`
 }
+
+type Outcome string
+
+const (
+	OutcomePassed   Outcome = "passed"
+	OutcomeFailed   Outcome = "failed"
+	OutcomeNoQuorum Outcome = "no quorum"
+)
+
+// Decision is the result of evaluating poll rules
+type Decision struct {
+	Outcome Outcome `json:"outcome"`
+	// Winner: leading option, nil if there is no single leader
+	Winner *Option `json:"winner,omitempty"`
+	// Turnout: total number of votes
+	Turnout int `json:"turnout"`
+	// Members: number of channel members at the moment of ending
+	Members int `json:"members"`
+}
+
+type EventType string
+
+const (
+	EventPollCreated EventType = "poll.created"
+	EventPollVoted   EventType = "poll.voted"
+	EventPollUnvoted EventType = "poll.unvoted"
+	EventPollEnded   EventType = "poll.ended"
+	EventPollDeleted EventType = "poll.deleted"
+)
+
+// Event describes a poll lifecycle change, sent as webhook payload
+type Event struct {
+	ID   string    `json:"id"`
+	Type EventType `json:"type"`
+	// ActorID: user who made the change, empty for automatic changes and votes in anonymous polls
+	ActorID   string    `json:"actor_id,omitempty"`
+	Poll      *Poll     `json:"poll"`
+	Decision  *Decision `json:"decision,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is a queued webhook request
+type Delivery struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Event         EventType `json:"event"`
+	Payload       string    `json:"payload"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// Bucket is a rate limit token bucket refilled by Rate tokens per millisecond up to Burst
+type Bucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditVote   AuditAction = "vote"
+	AuditUnvote AuditAction = "unvote"
+	AuditEnd    AuditAction = "end"
+	AuditDelete AuditAction = "delete"
+	// AuditUndelete and AuditPurge are restoring the poll from trash and removing it for good
+	AuditUndelete AuditAction = "undelete"
+	AuditPurge    AuditAction = "purge"
+	// AuditPin and AuditUnpin are keeping the poll from the retention policy and releasing it
+	AuditPin   AuditAction = "pin"
+	AuditUnpin AuditAction = "unpin"
+	// AuditAnonymize is replacing voter ids by the retention policy
+	AuditAnonymize AuditAction = "anonymize"
+)
+
+// AuditOK is the outcome of successful actions, failed ones store the error label
+const AuditOK = "ok"
+
+// AuditEntry is an append-only record of a poll mutation
+type AuditEntry struct {
+	ID     string      `json:"id"`
+	PollID string      `json:"poll_id"`
+	Action AuditAction `json:"action"`
+	// ActorID: user who made the change, empty for automatic changes and votes in anonymous polls
+	ActorID   string    `json:"actor_id,omitempty"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CountMismatch is a poll vote count which differs from the number of rows in the votes space
+type CountMismatch struct {
+	PollID   string `json:"poll_id"`
+	ChoiceID string `json:"choice_id"`
+	Stored   int    `json:"stored"`
+	Actual   int    `json:"actual"`
+}
+
+// IntegrityReport lists problems found by the integrity check
+type IntegrityReport struct {
+	Polls int `json:"polls"`
+	Votes int `json:"votes"`
+	// Orphans: votes of polls which do not exist or for options the poll does not have
+	Orphans    []Vote          `json:"orphans,omitempty"`
+	Mismatches []CountMismatch `json:"mismatches,omitempty"`
+	Repaired   bool            `json:"repaired"`
+}
+
+// OK reports whether no problems were found
+func (r *IntegrityReport) OK() bool {
+	return len(r.Orphans) == 0 && len(r.Mismatches) == 0
+}
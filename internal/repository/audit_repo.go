@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"math"
+	"slices"
+	"time"
+)
+
+type AuditRepository struct {
+	db conn
+	l  *zap.Logger
+}
+
+func NewAuditRepository(db tarantool.Connector, l *zap.Logger) *AuditRepository {
+	return &AuditRepository{
+		db: conn{db},
+		l:  l,
+	}
+}
+
+// Append inserts the entry, created_at is stored in milliseconds so that close actions keep their order
+func (r *AuditRepository) Append(entry *models.AuditEntry) error {
+	_, err := r.db.Insert("audit", []interface{}{
+		entry.ID,
+		entry.PollID,
+		string(entry.Action),
+		entry.ActorID,
+		entry.ChannelID,
+		entry.Outcome,
+		uint64(entry.CreatedAt.UnixMilli()),
+	})
+	if err != nil {
+		r.l.Debug("failed to insert audit entry", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// ListByPoll returns entries of the poll from the oldest to the newest
+func (r *AuditRepository) ListByPoll(pollID string) ([]*models.AuditEntry, error) {
+	resp, err := r.db.Select("audit", "poll", 0, math.MaxUint32, tarantool.IterEq, []interface{}{pollID})
+	if err != nil {
+		r.l.Debug("failed to select audit entries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	entries := make([]*models.AuditEntry, 0, len(resp.Data))
+	for _, data := range resp.Data {
+		auditTuple, ok := data.([]interface{})
+		if !ok || len(auditTuple) < 7 {
+			r.l.Debug("unexpected data type", zap.Any("data", data))
+			return nil, models.ErrFailedToProcessData
+		}
+		entry := &models.AuditEntry{}
+		entry.ID, _ = auditTuple[0].(string)
+		entry.PollID, _ = auditTuple[1].(string)
+		action, _ := auditTuple[2].(string)
+		entry.Action = models.AuditAction(action)
+		entry.ActorID, _ = auditTuple[3].(string)
+		entry.ChannelID, _ = auditTuple[4].(string)
+		entry.Outcome, _ = auditTuple[5].(string)
+		createdAt, _ := toInt64(auditTuple[6])
+		entry.CreatedAt = time.UnixMilli(createdAt)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// DeleteActions deletes entries of the poll with the given actions, returns the number of deleted entries
+func (r *AuditRepository) DeleteActions(pollID string, actions ...models.AuditAction) (int, error) {
+	entries, err := r.ListByPoll(pollID)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if !slices.Contains(actions, entry.Action) {
+			continue
+		}
+		if _, err = r.db.Delete("audit", "primary", []interface{}{entry.ID}); err != nil {
+			r.l.Debug("failed to delete audit entry", zap.Error(err))
+			return deleted, fmt.Errorf("repository: database delete error: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// DeleteBefore deletes up to limit entries created before t, returns the number of deleted entries
+func (r *AuditRepository) DeleteBefore(t time.Time, limit uint32) (int, error) {
+	resp, err := r.db.Select("audit", "created", 0, limit, tarantool.IterLt,
+		[]interface{}{uint64(t.UnixMilli())})
+	if err != nil {
+		r.l.Debug("failed to select expired audit entries", zap.Error(err))
+		return 0, fmt.Errorf("repository: database select error: %w", err)
+	}
+	deleted := 0
+	for _, data := range resp.Data {
+		auditTuple, ok := data.([]interface{})
+		if !ok || len(auditTuple) < 1 {
+			return deleted, models.ErrFailedToProcessData
+		}
+		if _, err = r.db.Delete("audit", "primary", []interface{}{auditTuple[0]}); err != nil {
+			r.l.Debug("failed to delete audit entry", zap.Error(err))
+			return deleted, fmt.Errorf("repository: database delete error: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,143 @@
+package repository_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jaam8/mattermost_bot/internal/repository"
+	"github.com/jaam8/mattermost_bot/internal/service"
+	"github.com/jaam8/mattermost_bot/pkg/postgres"
+	"github.com/jaam8/mattermost_bot/pkg/sqlite"
+	"github.com/jaam8/mattermost_bot/pkg/tarantool"
+	got "github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backend is the stores of one STORAGE, tests shared by the backends take it
+type backend struct {
+	polls   service.PollStore
+	audit   service.AuditStore
+	backups service.BackupStore
+}
+
+// backends lists the storages by name, each opens its stores or skips the test
+var backends = []struct {
+	name string
+	open func(t *testing.T) backend
+}{
+	{"tarantool", tarantoolBackend},
+	{"postgres", postgresBackend},
+	{"sqlite", sqliteBackend},
+}
+
+func tarantoolBackend(t *testing.T) backend {
+	t.Helper()
+	conn := testTarantool(t)
+	l := zap.NewNop()
+	return backend{
+		polls:   repository.New(conn, l),
+		audit:   repository.NewAuditRepository(conn, l),
+		backups: repository.NewBackupRepository(conn, l),
+	}
+}
+
+// postgresBackend connects to the database at TEST_POSTGRES_DSN and migrates it,
+// the test is skipped when it is not set. Tests use random ids, so the database can be shared
+func postgresBackend(t *testing.T) backend {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+	l := zap.NewNop()
+	pool, err := postgres.New(ctx, postgres.Config{DSN: dsn, MaxConns: 4})
+	if err != nil {
+		t.Fatalf("failed to connect to Postgres: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if err = repository.MigratePostgres(ctx, pool, l); err != nil {
+		t.Fatalf("failed to migrate Postgres: %v", err)
+	}
+	return backend{
+		polls:   repository.NewPostgresPollRepository(pool, l),
+		audit:   repository.NewPostgresAuditRepository(pool, l),
+		backups: repository.NewPostgresBackupRepository(pool, l),
+	}
+}
+
+// sqliteBackend opens a new migrated database file in a temporary directory
+func sqliteBackend(t *testing.T) backend {
+	t.Helper()
+	l := zap.NewNop()
+	db, err := sqlite.New(sqlite.Config{
+		Path:        filepath.Join(t.TempDir(), "mattermost_bot.db"),
+		BusyTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to open SQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err = repository.MigrateSQLite(context.Background(), db, l); err != nil {
+		t.Fatalf("failed to migrate SQLite: %v", err)
+	}
+	return backend{
+		polls:   repository.NewSQLitePollRepository(db, l),
+		audit:   repository.NewSQLiteAuditRepository(db, l),
+		backups: repository.NewSQLiteBackupRepository(db, l),
+	}
+}
+
+// testTarantool connects to the Tarantool started with tarantool/init.lua at TEST_TARANTOOL_ADDRS,
+// the test is skipped when it is not set. Tests use random ids, so the instance can be shared
+func testTarantool(t *testing.T) got.Connector {
+	t.Helper()
+	addrs := os.Getenv("TEST_TARANTOOL_ADDRS")
+	if addrs == "" {
+		t.Skip("TEST_TARANTOOL_ADDRS is not set")
+	}
+	cfg := tarantool.Config{
+		Addrs:          strings.Split(addrs, ","),
+		Username:       envOr("TEST_TARANTOOL_USER", "admin"),
+		Password:       envOr("TEST_TARANTOOL_PASSWORD", "secret"),
+		Timeout:        5 * time.Second,
+		StartupTimeout: 5 * time.Second,
+	}
+	conn, err := tarantool.New(context.Background(), cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to connect to Tarantool: %v", err)
+	}
+	t.Cleanup(func() { _ = tarantool.Close(conn) })
+	return conn
+}
+
+// newTestPoll returns an active poll with random id and the given number of options
+func newTestPoll(options int, settings models.Settings) *models.Poll {
+	poll := &models.Poll{
+		ID:        uuid.New().String(),
+		Question:  "test poll",
+		Votes:     map[string]int{},
+		CreatorID: "owner",
+		IsActive:  true,
+		ChannelID: "channel",
+		Settings:  settings,
+	}
+	for i := 1; i <= options; i++ {
+		poll.Options = append(poll.Options, models.Option{ID: i, Text: "option " + strconv.Itoa(i)})
+		poll.Votes[strconv.Itoa(i)] = 0
+	}
+	return poll
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
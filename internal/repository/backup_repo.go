@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"time"
+)
+
+// backupPage is how many tuples are selected at once while scanning a space
+const backupPage = 1000
+
+type FieldType string
+
+const (
+	FieldString   FieldType = "string"
+	FieldUnsigned FieldType = "unsigned"
+	FieldBoolean  FieldType = "boolean"
+	FieldArray    FieldType = "array"
+)
+
+type Field struct {
+	Name     string
+	Type     FieldType
+	Nullable bool
+}
+
+// Space describes the tuple format of a space as it is created by tarantool/init.lua
+type Space struct {
+	Name   string
+	Fields []Field
+}
+
+// BackupSpaces are spaces with poll data in the order they are restored, polls go before their votes.
+// Webhook deliveries and rate limits are not backed up, they only make sense in the running environment.
+// Postgres and SQLite tables are backed up in the same tuple format, so a backup can move data between backends
+var BackupSpaces = []Space{
+	{Name: "polls", Fields: []Field{
+		{Name: "id", Type: FieldString},
+		{Name: "question", Type: FieldString},
+		{Name: "options", Type: FieldArray},
+		{Name: "votes", Type: FieldString},
+		{Name: "creator_id", Type: FieldString},
+		{Name: "is_active", Type: FieldBoolean},
+		{Name: "channel_id", Type: FieldString, Nullable: true},
+		{Name: "settings", Type: FieldString, Nullable: true},
+		{Name: "post_id", Type: FieldString, Nullable: true},
+		{Name: "reminded", Type: FieldBoolean, Nullable: true},
+		{Name: "root_id", Type: FieldString, Nullable: true},
+		{Name: "deleted_at", Type: FieldUnsigned, Nullable: true},
+		{Name: "ended_at", Type: FieldUnsigned, Nullable: true},
+		{Name: "pinned", Type: FieldBoolean, Nullable: true},
+		{Name: "anonymized", Type: FieldBoolean, Nullable: true},
+	}},
+	{Name: "votes", Fields: []Field{
+		{Name: "poll_id", Type: FieldString},
+		{Name: "user_id", Type: FieldString},
+		{Name: "choice_id", Type: FieldString},
+	}},
+	{Name: "reminder_optouts", Fields: []Field{
+		{Name: "user_id", Type: FieldString},
+	}},
+	{Name: "reminders", Fields: []Field{
+		{Name: "user_id", Type: FieldString},
+		{Name: "sent_at", Type: FieldUnsigned},
+	}},
+	{Name: "audit", Fields: []Field{
+		{Name: "id", Type: FieldString},
+		{Name: "poll_id", Type: FieldString},
+		{Name: "action", Type: FieldString},
+		{Name: "actor_id", Type: FieldString},
+		{Name: "channel_id", Type: FieldString},
+		{Name: "outcome", Type: FieldString},
+		{Name: "created_at", Type: FieldUnsigned},
+	}},
+}
+
+type BackupRepository struct {
+	db conn
+	l  *zap.Logger
+}
+
+func NewBackupRepository(db tarantool.Connector, l *zap.Logger) *BackupRepository {
+	return &BackupRepository{
+		db: conn{db},
+		l:  l,
+	}
+}
+
+// Scan calls fn for every tuple of the space. Pages are selected by offset since primary
+// indexes of most spaces are hash ones, so the space should not be changed during the scan
+func (r *BackupRepository) Scan(space string, fn func(tuple []interface{}) error) error {
+	for offset := uint32(0); ; offset += backupPage {
+		resp, err := r.db.Select(space, "primary", offset, backupPage, tarantool.IterAll, []interface{}{})
+		if err != nil {
+			r.l.Debug("failed to select tuples", zap.String("space", space), zap.Error(err))
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		for _, data := range resp.Data {
+			tuple, ok := data.([]interface{})
+			if !ok {
+				return fmt.Errorf("repository: unexpected tuple type %T in space %s", data, space)
+			}
+			if err = fn(tuple); err != nil {
+				return err
+			}
+		}
+		if len(resp.Data) < backupPage {
+			return nil
+		}
+	}
+}
+
+// Put replaces the tuple, so restoring the same tuple again does not change the space.
+// Audit entries can not be replaced, so existing ones are skipped
+func (r *BackupRepository) Put(space string, tuple []interface{}) error {
+	if space == "audit" {
+		_, err := r.db.Insert(space, tuple)
+		var terr tarantool.Error
+		if err != nil && !(errors.As(err, &terr) && terr.Code == tarantool.ErrTupleFound) {
+			r.l.Debug("failed to insert tuple", zap.String("space", space), zap.Error(err))
+			return fmt.Errorf("repository: database insert error: %w", err)
+		}
+		return nil
+	}
+	if _, err := r.db.Replace(space, tuple); err != nil {
+		r.l.Debug("failed to replace tuple", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database replace error: %w", err)
+	}
+	return nil
+}
+
+// pollToTuple converts the poll to the tuple of the polls space, times are unix seconds
+func pollToTuple(poll *models.Poll) ([]interface{}, error) {
+	optionsJSON, votes, settings, err := marshalPoll(poll)
+	if err != nil {
+		return nil, err
+	}
+	var options []interface{}
+	if err = json.Unmarshal(optionsJSON, &options); err != nil {
+		return nil, fmt.Errorf("repository: failed to unmarshal options: %w", err)
+	}
+	return []interface{}{
+		poll.ID,
+		poll.Question,
+		options,
+		string(votes),
+		poll.CreatorID,
+		poll.IsActive,
+		poll.ChannelID,
+		string(settings),
+		poll.PostID,
+		poll.Reminded,
+		poll.RootID,
+		unixOrNil(poll.DeletedAt),
+		unixOrNil(poll.EndedAt),
+		poll.Pinned,
+		poll.Anonymized,
+	}, nil
+}
+
+// pollFromBackupTuple converts the tuple of the polls space checked against BackupSpaces to the poll,
+// missing nullable fields get zero values
+func pollFromBackupTuple(tuple []interface{}) (*models.Poll, error) {
+	field := func(i int) interface{} {
+		if i < len(tuple) {
+			return tuple[i]
+		}
+		return nil
+	}
+	poll := &models.Poll{}
+	poll.ID, _ = field(0).(string)
+	poll.Question, _ = field(1).(string)
+	options, err := json.Marshal(field(2))
+	if err != nil {
+		return nil, fmt.Errorf("repository: json marshal error: %w", err)
+	}
+	votes, _ := field(3).(string)
+	settings, _ := field(7).(string)
+	if err = unmarshalPoll(poll, options, []byte(votes), []byte(settings)); err != nil {
+		return nil, err
+	}
+	poll.CreatorID, _ = field(4).(string)
+	poll.IsActive, _ = field(5).(bool)
+	poll.ChannelID, _ = field(6).(string)
+	poll.PostID, _ = field(8).(string)
+	poll.Reminded, _ = field(9).(bool)
+	poll.RootID, _ = field(10).(string)
+	if deletedAt, ok := field(11).(uint64); ok {
+		t := time.Unix(int64(deletedAt), 0)
+		poll.DeletedAt = &t
+	}
+	if endedAt, ok := field(12).(uint64); ok {
+		t := time.Unix(int64(endedAt), 0)
+		poll.EndedAt = &t
+	}
+	poll.Pinned, _ = field(13).(bool)
+	poll.Anonymized, _ = field(14).(bool)
+	return poll, nil
+}
+
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return uint64(t.Unix())
+}
+
+// tupleStrings returns the string fields of a tuple checked against BackupSpaces
+func tupleStrings(tuple []interface{}, n int) []interface{} {
+	values := make([]interface{}, n)
+	for i := range values {
+		values[i] = ""
+		if i < len(tuple) {
+			if value, ok := tuple[i].(string); ok {
+				values[i] = value
+			}
+		}
+	}
+	return values
+}
+
+// tupleUnsigned returns the unsigned field of a tuple checked against BackupSpaces
+func tupleUnsigned(tuple []interface{}, i int) uint64 {
+	if i < len(tuple) {
+		value, _ := tuple[i].(uint64)
+		return value
+	}
+	return 0
+}
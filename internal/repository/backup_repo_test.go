@@ -0,0 +1,90 @@
+package repository_test
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jaam8/mattermost_bot/internal/service"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// TestRestoreTwice restores the backup into the storage it was taken from twice,
+// append-only audit entries must be skipped instead of failing the restore
+func TestRestoreTwice(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			testRestoreTwice(t, b.open(t))
+		})
+	}
+}
+
+func testRestoreTwice(t *testing.T, b backend) {
+	polls, audit := b.polls, b.audit
+	poll := newTestPoll(2, models.Settings{})
+	if _, _, err := polls.CreatePoll(poll); err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	if err := polls.Vote(poll.ID, "1", "voter"); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	entry := &models.AuditEntry{
+		ID:        uuid.New().String(),
+		PollID:    poll.ID,
+		Action:    models.AuditCreate,
+		ActorID:   poll.CreatorID,
+		ChannelID: poll.ChannelID,
+		Outcome:   models.AuditOK,
+		CreatedAt: time.Now(),
+	}
+	if err := audit.Append(entry); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	backups := service.NewBackups(b.backups, zap.NewNop())
+	var backup bytes.Buffer
+	if _, err := backups.Backup(&backup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		if _, err := backups.Restore(bytes.NewReader(backup.Bytes()), false); err != nil {
+			t.Fatalf("Restore #%d: %v", i, err)
+		}
+	}
+	var again bytes.Buffer
+	if _, err := backups.Backup(&again); err != nil {
+		t.Fatalf("Backup after restore: %v", err)
+	}
+	if records(backup.Bytes()) != records(again.Bytes()) {
+		t.Errorf("backup after restore differs:\n%s\nwant:\n%s", records(again.Bytes()), records(backup.Bytes()))
+	}
+
+	entries, err := audit.ListByPoll(poll.ID)
+	if err != nil {
+		t.Fatalf("ListByPoll: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != entry.ID {
+		t.Errorf("audit entries after restore = %+v, want only %s", entries, entry.ID)
+	}
+	restored, err := polls.GetPollResult(poll.ID)
+	if err != nil {
+		t.Fatalf("GetPollResult: %v", err)
+	}
+	if restored.Votes["1"] != 1 {
+		t.Errorf("votes after restore = %v, want one vote for 1", restored.Votes)
+	}
+	voters, err := polls.GetVoters(poll.ID)
+	if err != nil {
+		t.Fatalf("GetVoters: %v", err)
+	}
+	if len(voters) != 1 {
+		t.Errorf("voters after restore = %v, want one", voters)
+	}
+}
+
+// records drops the header line of the backup, it keeps the time the backup was taken
+func records(backup []byte) string {
+	_, records, _ := bytes.Cut(backup, []byte("\n"))
+	return string(records)
+}
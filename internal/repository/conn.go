@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/metrics"
+	"github.com/tarantool/go-tarantool"
+	"time"
+)
+
+// conn wraps tarantool connection or multi connection to record request latency
+type conn struct {
+	tarantool.Connector
+}
+
+func (c conn) Select(space, index interface{}, offset, limit, iterator uint32, key interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Select(space, index, offset, limit, iterator, key)
+	metrics.ObserveTarantool("select", fmt.Sprint(space), start, err)
+	return resp, err
+}
+
+func (c conn) Insert(space interface{}, tuple interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Insert(space, tuple)
+	metrics.ObserveTarantool("insert", fmt.Sprint(space), start, err)
+	return resp, err
+}
+
+func (c conn) Replace(space interface{}, tuple interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Replace(space, tuple)
+	metrics.ObserveTarantool("replace", fmt.Sprint(space), start, err)
+	return resp, err
+}
+
+func (c conn) Update(space, index interface{}, key, ops interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Update(space, index, key, ops)
+	metrics.ObserveTarantool("update", fmt.Sprint(space), start, err)
+	return resp, err
+}
+
+func (c conn) Delete(space, index interface{}, key interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Delete(space, index, key)
+	metrics.ObserveTarantool("delete", fmt.Sprint(space), start, err)
+	return resp, err
+}
+
+func (c conn) Call17(functionName string, args interface{}) (*tarantool.Response, error) {
+	start := time.Now()
+	resp, err := c.Connector.Call17(functionName, args)
+	metrics.ObserveTarantool("call", functionName, start, err)
+	return resp, err
+}
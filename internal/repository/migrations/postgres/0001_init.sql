@@ -0,0 +1,72 @@
+-- polls mirror the polls space of tarantool/init.lua, votes keeps vote counts by choice id
+CREATE TABLE polls (
+    id          text PRIMARY KEY,
+    question    text        NOT NULL,
+    options     jsonb       NOT NULL,
+    votes       jsonb       NOT NULL,
+    creator_id  text        NOT NULL,
+    is_active   boolean     NOT NULL DEFAULT true,
+    channel_id  text        NOT NULL DEFAULT '',
+    settings    jsonb       NOT NULL DEFAULT '{}',
+    post_id     text        NOT NULL DEFAULT '',
+    reminded    boolean     NOT NULL DEFAULT false,
+    root_id     text        NOT NULL DEFAULT '',
+    deleted_at  timestamptz,
+    ended_at    timestamptz,
+    pinned      boolean     NOT NULL DEFAULT false,
+    anonymized  boolean     NOT NULL DEFAULT false
+);
+
+CREATE INDEX polls_post_id ON polls (post_id) WHERE post_id <> '';
+CREATE INDEX polls_deleted_at ON polls (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- unique per choice instead of (poll_id, user_id), polls with max_choices keep a row per chosen option
+CREATE TABLE votes (
+    poll_id    text NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+    user_id    text NOT NULL,
+    choice_id  text NOT NULL,
+    CONSTRAINT votes_poll_user_choice UNIQUE (poll_id, user_id, choice_id)
+);
+
+CREATE TABLE reminder_optouts (
+    user_id text PRIMARY KEY
+);
+
+CREATE TABLE reminders (
+    user_id  text PRIMARY KEY,
+    sent_at  timestamptz NOT NULL
+);
+
+CREATE TABLE audit (
+    id          text PRIMARY KEY,
+    poll_id     text        NOT NULL,
+    action      text        NOT NULL,
+    actor_id    text        NOT NULL,
+    channel_id  text        NOT NULL,
+    outcome     text        NOT NULL,
+    created_at  timestamptz NOT NULL
+);
+
+CREATE INDEX audit_poll_id ON audit (poll_id, created_at);
+CREATE INDEX audit_created_at ON audit (created_at);
+
+-- audit entries are append-only: they can be inserted and deleted by retention, but never changed
+CREATE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit entries are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_append_only BEFORE UPDATE ON audit
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+
+CREATE TABLE webhook_deliveries (
+    id               text PRIMARY KEY,
+    url              text        NOT NULL,
+    event            text        NOT NULL,
+    payload          text        NOT NULL,
+    attempts         integer     NOT NULL DEFAULT 0,
+    next_attempt_at  timestamptz NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
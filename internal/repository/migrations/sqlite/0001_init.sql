@@ -0,0 +1,69 @@
+-- polls mirror the polls space of tarantool/init.lua, times are unix seconds and JSON is kept as text
+CREATE TABLE polls (
+    id          TEXT PRIMARY KEY,
+    question    TEXT    NOT NULL,
+    options     TEXT    NOT NULL,
+    votes       TEXT    NOT NULL,
+    creator_id  TEXT    NOT NULL,
+    is_active   INTEGER NOT NULL DEFAULT 1,
+    channel_id  TEXT    NOT NULL DEFAULT '',
+    settings    TEXT    NOT NULL DEFAULT '{}',
+    post_id     TEXT    NOT NULL DEFAULT '',
+    reminded    INTEGER NOT NULL DEFAULT 0,
+    root_id     TEXT    NOT NULL DEFAULT '',
+    deleted_at  INTEGER,
+    ended_at    INTEGER,
+    pinned      INTEGER NOT NULL DEFAULT 0,
+    anonymized  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX polls_post_id ON polls (post_id) WHERE post_id <> '';
+CREATE INDEX polls_deleted_at ON polls (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- unique per choice instead of (poll_id, user_id), polls with max_choices keep a row per chosen option
+CREATE TABLE votes (
+    poll_id    TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL,
+    choice_id  TEXT NOT NULL,
+    PRIMARY KEY (poll_id, user_id, choice_id)
+);
+
+CREATE TABLE reminder_optouts (
+    user_id TEXT PRIMARY KEY
+);
+
+CREATE TABLE reminders (
+    user_id  TEXT PRIMARY KEY,
+    sent_at  INTEGER NOT NULL
+);
+
+-- created_at is in milliseconds so that close actions keep their order
+CREATE TABLE audit (
+    id          TEXT PRIMARY KEY,
+    poll_id     TEXT    NOT NULL,
+    action      TEXT    NOT NULL,
+    actor_id    TEXT    NOT NULL,
+    channel_id  TEXT    NOT NULL,
+    outcome     TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL
+);
+
+CREATE INDEX audit_poll_id ON audit (poll_id, created_at);
+CREATE INDEX audit_created_at ON audit (created_at);
+
+-- audit entries are append-only: they can be inserted and deleted by retention, but never changed
+CREATE TRIGGER audit_append_only BEFORE UPDATE ON audit
+BEGIN
+    SELECT RAISE(ABORT, 'audit entries are append-only');
+END;
+
+CREATE TABLE webhook_deliveries (
+    id               TEXT PRIMARY KEY,
+    url              TEXT    NOT NULL,
+    event            TEXT    NOT NULL,
+    payload          TEXT    NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  INTEGER NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
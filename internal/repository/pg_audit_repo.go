@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PostgresAuditRepository struct {
+	db *pgxpool.Pool
+	l  *zap.Logger
+}
+
+func NewPostgresAuditRepository(db *pgxpool.Pool, l *zap.Logger) *PostgresAuditRepository {
+	return &PostgresAuditRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+func (r *PostgresAuditRepository) Append(entry *models.AuditEntry) error {
+	_, err := r.db.Exec(context.Background(), `INSERT INTO audit
+		(id, poll_id, action, actor_id, channel_id, outcome, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		entry.ID, entry.PollID, string(entry.Action), entry.ActorID, entry.ChannelID, entry.Outcome, entry.CreatedAt)
+	if err != nil {
+		r.l.Debug("failed to insert audit entry", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// ListByPoll returns entries of the poll from the oldest to the newest
+func (r *PostgresAuditRepository) ListByPoll(pollID string) ([]*models.AuditEntry, error) {
+	rows, err := r.db.Query(context.Background(), `SELECT id, poll_id, action, actor_id, channel_id, outcome, created_at
+		FROM audit WHERE poll_id = $1 ORDER BY created_at, id`, pollID)
+	if err != nil {
+		r.l.Debug("failed to select audit entries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.AuditEntry, error) {
+		entry := &models.AuditEntry{}
+		err := row.Scan(&entry.ID, &entry.PollID, &entry.Action, &entry.ActorID, &entry.ChannelID,
+			&entry.Outcome, &entry.CreatedAt)
+		return entry, err
+	})
+	if err != nil {
+		r.l.Debug("failed to select audit entries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return entries, nil
+}
+
+// DeleteActions deletes entries of the poll with the given actions, returns the number of deleted entries
+func (r *PostgresAuditRepository) DeleteActions(pollID string, actions ...models.AuditAction) (int, error) {
+	names := make([]string, 0, len(actions))
+	for _, action := range actions {
+		names = append(names, string(action))
+	}
+	tag, err := r.db.Exec(context.Background(), `DELETE FROM audit WHERE poll_id = $1 AND action = ANY($2)`,
+		pollID, names)
+	if err != nil {
+		r.l.Debug("failed to delete audit entries", zap.Error(err))
+		return 0, fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// DeleteBefore deletes up to limit entries created before t, returns the number of deleted entries
+func (r *PostgresAuditRepository) DeleteBefore(t time.Time, limit uint32) (int, error) {
+	tag, err := r.db.Exec(context.Background(), `DELETE FROM audit WHERE id IN
+		(SELECT id FROM audit WHERE created_at < $1 ORDER BY created_at LIMIT $2)`, t, int64(limit))
+	if err != nil {
+		r.l.Debug("failed to delete expired audit entries", zap.Error(err))
+		return 0, fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
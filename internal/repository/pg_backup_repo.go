@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+// PostgresBackupRepository reads and writes Postgres tables as tuples of BackupSpaces
+type PostgresBackupRepository struct {
+	db *pgxpool.Pool
+	l  *zap.Logger
+}
+
+func NewPostgresBackupRepository(db *pgxpool.Pool, l *zap.Logger) *PostgresBackupRepository {
+	return &PostgresBackupRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+// Scan calls fn for every row of the table of the space
+func (r *PostgresBackupRepository) Scan(space string, fn func(tuple []interface{}) error) error {
+	ctx := context.Background()
+	var query string
+	var scan func(rows pgx.Rows) ([]interface{}, error)
+	switch space {
+	case "polls":
+		query = "SELECT " + pollColumns + " FROM polls ORDER BY id"
+		scan = func(rows pgx.Rows) ([]interface{}, error) {
+			poll, err := scanPoll(rows)
+			if err != nil {
+				return nil, err
+			}
+			return pollToTuple(poll)
+		}
+	case "votes":
+		query = "SELECT poll_id, user_id, choice_id FROM votes ORDER BY poll_id, user_id, choice_id"
+		scan = func(rows pgx.Rows) ([]interface{}, error) {
+			var pollID, userID, choiceID string
+			err := rows.Scan(&pollID, &userID, &choiceID)
+			return []interface{}{pollID, userID, choiceID}, err
+		}
+	case "reminder_optouts":
+		query = "SELECT user_id FROM reminder_optouts ORDER BY user_id"
+		scan = func(rows pgx.Rows) ([]interface{}, error) {
+			var userID string
+			err := rows.Scan(&userID)
+			return []interface{}{userID}, err
+		}
+	case "reminders":
+		query = "SELECT user_id, sent_at FROM reminders ORDER BY user_id"
+		scan = func(rows pgx.Rows) ([]interface{}, error) {
+			var userID string
+			var sentAt time.Time
+			err := rows.Scan(&userID, &sentAt)
+			return []interface{}{userID, uint64(sentAt.Unix())}, err
+		}
+	case "audit":
+		query = `SELECT id, poll_id, action, actor_id, channel_id, outcome, created_at
+			FROM audit ORDER BY created_at, id`
+		scan = func(rows pgx.Rows) ([]interface{}, error) {
+			var id, pollID, action, actorID, channelID, outcome string
+			var createdAt time.Time
+			err := rows.Scan(&id, &pollID, &action, &actorID, &channelID, &outcome, &createdAt)
+			return []interface{}{id, pollID, action, actorID, channelID, outcome, uint64(createdAt.UnixMilli())}, err
+		}
+	default:
+		return fmt.Errorf("repository: unknown backup space %s", space)
+	}
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		r.l.Debug("failed to select rows", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tuple, err := scan(rows)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		if err = fn(tuple); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select rows", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database select error: %w", err)
+	}
+	return nil
+}
+
+// Put upserts the row of the tuple, so restoring the same tuple again does not change the table.
+// Audit entries can not be updated, so existing ones are skipped
+func (r *PostgresBackupRepository) Put(space string, tuple []interface{}) error {
+	ctx := context.Background()
+	var err error
+	switch space {
+	case "polls":
+		err = r.putPoll(ctx, tuple)
+	case "votes":
+		_, err = r.db.Exec(ctx, `INSERT INTO votes (poll_id, user_id, choice_id) VALUES ($1, $2, $3)
+			ON CONFLICT ON CONSTRAINT votes_poll_user_choice DO NOTHING`, tupleStrings(tuple, 3)...)
+	case "reminder_optouts":
+		_, err = r.db.Exec(ctx, "INSERT INTO reminder_optouts (user_id) VALUES ($1) ON CONFLICT DO NOTHING",
+			tupleStrings(tuple, 1)...)
+	case "reminders":
+		_, err = r.db.Exec(ctx, `INSERT INTO reminders (user_id, sent_at) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET sent_at = EXCLUDED.sent_at`,
+			tupleStrings(tuple, 1)[0], time.Unix(int64(tupleUnsigned(tuple, 1)), 0))
+	case "audit":
+		args := append(tupleStrings(tuple, 6), time.UnixMilli(int64(tupleUnsigned(tuple, 6))))
+		_, err = r.db.Exec(ctx, `INSERT INTO audit (id, poll_id, action, actor_id, channel_id, outcome, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (id) DO NOTHING`, args...)
+	default:
+		return fmt.Errorf("repository: unknown backup space %s", space)
+	}
+	if err != nil {
+		r.l.Debug("failed to upsert row", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database upsert error: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresBackupRepository) putPoll(ctx context.Context, tuple []interface{}) error {
+	poll, err := pollFromBackupTuple(tuple)
+	if err != nil {
+		return err
+	}
+	options, votes, settings, err := marshalPoll(poll)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(ctx, `INSERT INTO polls (`+pollColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (id) DO UPDATE SET question = EXCLUDED.question, options = EXCLUDED.options,
+			votes = EXCLUDED.votes, creator_id = EXCLUDED.creator_id, is_active = EXCLUDED.is_active,
+			channel_id = EXCLUDED.channel_id, settings = EXCLUDED.settings, post_id = EXCLUDED.post_id,
+			reminded = EXCLUDED.reminded, root_id = EXCLUDED.root_id, deleted_at = EXCLUDED.deleted_at,
+			ended_at = EXCLUDED.ended_at, pinned = EXCLUDED.pinned, anonymized = EXCLUDED.anonymized`,
+		poll.ID, poll.Question, options, votes, poll.CreatorID, poll.IsActive, poll.ChannelID, settings,
+		poll.PostID, poll.Reminded, poll.RootID, poll.DeletedAt, poll.EndedAt, poll.Pinned, poll.Anonymized)
+	return err
+}
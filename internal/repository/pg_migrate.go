@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// migrationsLock is the advisory lock key held while migrating, so replicas starting together
+// do not apply the same migration twice
+const migrationsLock = 7316402
+
+// MigratePostgres applies migrations which are not applied yet in the order of their file names,
+// each migration runs in its own transaction
+func MigratePostgres(ctx context.Context, db *pgxpool.Pool, l *zap.Logger) error {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return fmt.Errorf("repository: failed to list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	c, err := db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("repository: failed to acquire connection: %w", err)
+	}
+	defer c.Release()
+	if _, err = c.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationsLock); err != nil {
+		return fmt.Errorf("repository: failed to lock migrations: %w", err)
+	}
+	defer c.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLock)
+
+	_, err = c.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    text PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("repository: failed to create schema_migrations: %w", err)
+	}
+	for _, name := range names {
+		version := strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], ".sql")
+		var applied bool
+		err = c.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).
+			Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("repository: failed to check migration %s: %w", version, err)
+		}
+		if applied {
+			continue
+		}
+		migration, err := postgresMigrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("repository: failed to read migration %s: %w", version, err)
+		}
+		err = pgx.BeginFunc(ctx, c, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, string(migration)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("repository: failed to apply migration %s: %w", version, err)
+		}
+		l.Info("applied migration", zap.String("version", version))
+	}
+	return nil
+}
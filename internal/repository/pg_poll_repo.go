@@ -0,0 +1,476 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// pollColumns are selected in the order scanPoll reads them
+const pollColumns = `id, question, options, votes, creator_id, is_active, channel_id, settings,
+	post_id, reminded, root_id, deleted_at, ended_at, pinned, anonymized`
+
+// PostgresPollRepository keeps polls and votes in Postgres, votes and their counts
+// are changed in one transaction holding the poll row lock
+type PostgresPollRepository struct {
+	db *pgxpool.Pool
+	l  *zap.Logger
+}
+
+func NewPostgresPollRepository(db *pgxpool.Pool, l *zap.Logger) *PostgresPollRepository {
+	return &PostgresPollRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+func (r *PostgresPollRepository) CreatePoll(poll *models.Poll) (string, []models.Option, error) {
+	r.l.Debug("creating poll", zap.Any("poll", poll))
+	options, votes, settings, err := marshalPoll(poll)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = r.db.Exec(context.Background(), `INSERT INTO polls
+		(id, question, options, votes, creator_id, is_active, channel_id, settings, post_id, reminded, root_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		poll.ID, poll.Question, options, votes, poll.CreatorID, poll.IsActive, poll.ChannelID, settings,
+		poll.PostID, poll.Reminded, poll.RootID)
+	if err != nil {
+		r.l.Debug("error inserting poll", zap.Error(err))
+		return "", nil, fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return poll.ID, poll.Options, nil
+}
+
+func (r *PostgresPollRepository) Vote(pollID, choiceID, userID string) error {
+	return r.changeVote(pollID, choiceID, func(ctx context.Context, tx pgx.Tx, poll *models.Poll) error {
+		rows, err := tx.Query(ctx, "SELECT choice_id FROM votes WHERE poll_id = $1 AND user_id = $2", pollID, userID)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		choices, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		for _, choice := range choices {
+			if choice == choiceID {
+				r.l.Debug("vote already exist",
+					zap.String("poll_id", pollID),
+					zap.String("user_id", userID))
+				return models.ErrVoteAlreadyExists
+			}
+		}
+		if maxChoices := poll.Settings.Choices(); len(choices) >= maxChoices {
+			r.l.Debug("user has no choices left",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			if maxChoices == 1 {
+				return models.ErrVoteAlreadyExists
+			}
+			return models.ErrTooManyChoices
+		}
+		tag, err := tx.Exec(ctx, `INSERT INTO votes (poll_id, user_id, choice_id) VALUES ($1, $2, $3)
+			ON CONFLICT ON CONSTRAINT votes_poll_user_choice DO NOTHING`, pollID, userID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database insert error: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return models.ErrVoteAlreadyExists
+		}
+		_, err = tx.Exec(ctx, `UPDATE polls SET votes = jsonb_set(votes, ARRAY[$2::text],
+			to_jsonb(COALESCE((votes ->> $2::text)::int, 0) + 1)) WHERE id = $1`, pollID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database update error: %w", err)
+		}
+		return nil
+	})
+}
+
+// Unvote removes the user vote for the choice from an active poll
+func (r *PostgresPollRepository) Unvote(pollID, choiceID, userID string) error {
+	return r.changeVote(pollID, choiceID, func(ctx context.Context, tx pgx.Tx, poll *models.Poll) error {
+		tag, err := tx.Exec(ctx, "DELETE FROM votes WHERE poll_id = $1 AND user_id = $2 AND choice_id = $3",
+			pollID, userID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database delete error: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return models.ErrVoteNotFound
+		}
+		_, err = tx.Exec(ctx, `UPDATE polls SET votes = jsonb_set(votes, ARRAY[$2::text],
+			to_jsonb(GREATEST(COALESCE((votes ->> $2::text)::int, 0) - 1, 0))) WHERE id = $1`, pollID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database update error: %w", err)
+		}
+		return nil
+	})
+}
+
+// changeVote locks the poll row, checks that the poll accepts votes for the choice and runs change
+// in the same transaction
+func (r *PostgresPollRepository) changeVote(pollID, choiceID string,
+	change func(ctx context.Context, tx pgx.Tx, poll *models.Poll) error) error {
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		poll, err := scanPoll(tx.QueryRow(ctx,
+			"SELECT "+pollColumns+" FROM polls WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", pollID))
+		if err != nil {
+			return err
+		}
+		if !poll.IsActive || poll.Settings.Deadline != nil && !time.Now().Before(*poll.Settings.Deadline) {
+			r.l.Debug("poll is not active", zap.String("poll_id", pollID))
+			return models.ErrPollIsEnd
+		}
+		if _, ok := poll.Votes[choiceID]; !ok {
+			r.l.Debug("option not found", zap.String("choice_id", choiceID))
+			return models.ErrOptionIsNotFound
+		}
+		return change(ctx, tx, poll)
+	})
+	if err != nil {
+		r.l.Debug("failed to change vote", zap.String("poll_id", pollID), zap.Error(err))
+	}
+	return err
+}
+
+// GetPollResult returns the poll, deleted polls are not found
+func (r *PostgresPollRepository) GetPollResult(pollID string) (*models.Poll, error) {
+	return r.getPoll("id = $1 AND deleted_at IS NULL", pollID)
+}
+
+// GetPollByPost returns the poll announced in the post
+func (r *PostgresPollRepository) GetPollByPost(postID string) (*models.Poll, error) {
+	return r.getPoll("post_id = $1 AND deleted_at IS NULL", postID)
+}
+
+func (r *PostgresPollRepository) getPoll(where string, arg string) (*models.Poll, error) {
+	poll, err := scanPoll(r.db.QueryRow(context.Background(),
+		"SELECT "+pollColumns+" FROM polls WHERE "+where+" LIMIT 1", arg))
+	if err != nil {
+		r.l.Debug("failed to select poll", zap.String("key", arg), zap.Error(err))
+		return nil, err
+	}
+	return poll, nil
+}
+
+// ListPolls returns polls which are not deleted
+func (r *PostgresPollRepository) ListPolls() ([]*models.Poll, error) {
+	return r.listPolls("SELECT " + pollColumns + " FROM polls WHERE deleted_at IS NULL ORDER BY id")
+}
+
+// ListAllPolls returns all polls including deleted ones
+func (r *PostgresPollRepository) ListAllPolls() ([]*models.Poll, error) {
+	return r.listPolls("SELECT " + pollColumns + " FROM polls ORDER BY id")
+}
+
+func (r *PostgresPollRepository) listPolls(query string) ([]*models.Poll, error) {
+	rows, err := r.db.Query(context.Background(), query)
+	if err != nil {
+		r.l.Debug("failed to select polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var polls []*models.Poll
+	for rows.Next() {
+		poll, err := scanPoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, poll)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return polls, nil
+}
+
+func (r *PostgresPollRepository) SetPostID(pollID, postID, rootID string) error {
+	return r.exec("UPDATE polls SET post_id = $2, root_id = $3 WHERE id = $1", pollID, postID, rootID)
+}
+
+func (r *PostgresPollRepository) SetReminded(pollID string) error {
+	return r.exec("UPDATE polls SET reminded = true WHERE id = $1", pollID)
+}
+
+func (r *PostgresPollRepository) EndPoll(pollID, userID string) error {
+	poll, err := r.GetPollResult(pollID)
+	if err != nil {
+		return err
+	}
+	if !poll.IsActive {
+		r.l.Debug("poll is not active", zap.String("poll_id", pollID))
+		return models.ErrPollAlreadyEnded
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	return r.Deactivate(pollID)
+}
+
+// Deactivate ends the active poll without checking its owner, an ended poll is not changed
+// and ErrPollAlreadyEnded is returned, so only one of concurrent calls ends it
+func (r *PostgresPollRepository) Deactivate(pollID string) error {
+	err := r.update("UPDATE polls SET is_active = false, ended_at = now() WHERE id = $1 AND is_active", pollID)
+	if errors.Is(err, models.ErrPollNotFound) {
+		if _, err = r.getPoll("id = $1", pollID); err != nil {
+			return err
+		}
+		return models.ErrPollAlreadyEnded
+	}
+	return err
+}
+
+// DeletePoll moves the poll to trash, it is hidden from all reads until restored or purged
+func (r *PostgresPollRepository) DeletePoll(pollID, userID string, now time.Time) error {
+	poll, err := r.GetPollResult(pollID)
+	if err != nil {
+		return err
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	return r.update("UPDATE polls SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL", pollID, now)
+}
+
+// UndeletePoll restores the poll deleted after notBefore
+func (r *PostgresPollRepository) UndeletePoll(pollID, userID string, notBefore time.Time) error {
+	poll, err := r.getPoll("id = $1", pollID)
+	if err != nil {
+		return err
+	}
+	if poll.DeletedAt == nil {
+		return models.ErrPollNotDeleted
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	if poll.DeletedAt.Before(notBefore) {
+		return models.ErrUndeleteExpired
+	}
+	return r.exec("UPDATE polls SET deleted_at = NULL WHERE id = $1", pollID)
+}
+
+// DeletedBefore returns ids of up to limit polls deleted before t
+func (r *PostgresPollRepository) DeletedBefore(t time.Time, limit uint32) ([]string, error) {
+	rows, err := r.db.Query(context.Background(),
+		"SELECT id FROM polls WHERE deleted_at < $1 ORDER BY deleted_at LIMIT $2", t, int64(limit))
+	if err != nil {
+		r.l.Debug("failed to select deleted polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		r.l.Debug("failed to select deleted polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return ids, nil
+}
+
+// PurgePoll removes the poll, its votes are removed by the foreign key in the same transaction
+func (r *PostgresPollRepository) PurgePoll(pollID string) error {
+	return r.exec("DELETE FROM polls WHERE id = $1", pollID)
+}
+
+// SetEndedAt sets the time the poll was ended, it is used for polls ended before the time was stored
+func (r *PostgresPollRepository) SetEndedAt(pollID string, endedAt time.Time) error {
+	return r.update("UPDATE polls SET ended_at = $2 WHERE id = $1 AND deleted_at IS NULL", pollID, endedAt)
+}
+
+// SetPinned pins the poll so that the retention policy keeps it or releases the pin
+func (r *PostgresPollRepository) SetPinned(pollID string, pinned bool) error {
+	return r.update("UPDATE polls SET pinned = $2 WHERE id = $1 AND deleted_at IS NULL", pollID, pinned)
+}
+
+// AnonymizeVotes replaces voter ids of the poll with anon:N and marks it as anonymized in one transaction,
+// one voter gets the same id for all choices so the number of voters is kept
+func (r *PostgresPollRepository) AnonymizeVotes(pollID string) error {
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `UPDATE votes SET user_id = 'anon:' || voters.n
+			FROM (SELECT user_id, row_number() OVER (ORDER BY user_id) AS n
+				FROM votes WHERE poll_id = $1 GROUP BY user_id) AS voters
+			WHERE votes.poll_id = $1 AND votes.user_id = voters.user_id`, pollID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE polls SET anonymized = true WHERE id = $1", pollID)
+		return err
+	})
+	if err != nil {
+		r.l.Debug("failed to anonymize votes", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// GetVoters returns ids of users who voted in the poll
+func (r *PostgresPollRepository) GetVoters(pollID string) ([]string, error) {
+	votes, err := r.GetVotes(pollID)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVoters(votes), nil
+}
+
+func (r *PostgresPollRepository) GetVotes(pollID string) ([]models.Vote, error) {
+	return r.selectVotes("SELECT poll_id, user_id, choice_id FROM votes WHERE poll_id = $1 ORDER BY user_id, choice_id",
+		pollID)
+}
+
+// ListAllVotes returns rows of the votes table of all polls
+func (r *PostgresPollRepository) ListAllVotes() ([]models.Vote, error) {
+	return r.selectVotes("SELECT poll_id, user_id, choice_id FROM votes ORDER BY poll_id, user_id, choice_id")
+}
+
+// DeleteVote removes the vote row without changing the poll counts
+func (r *PostgresPollRepository) DeleteVote(vote models.Vote) error {
+	return r.exec("DELETE FROM votes WHERE poll_id = $1 AND user_id = $2 AND choice_id = $3",
+		vote.PollID, vote.UserID, vote.ChoiceID)
+}
+
+// RecountVotes sets the vote counts of the poll to the numbers of its votes, the poll row
+// is locked like in changeVote so votes cast meanwhile wait for the recount
+func (r *PostgresPollRepository) RecountVotes(pollID string) error {
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		poll, err := scanPoll(tx.QueryRow(ctx, "SELECT "+pollColumns+" FROM polls WHERE id = $1 FOR UPDATE", pollID))
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, "SELECT choice_id FROM votes WHERE poll_id = $1", pollID)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		choices, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		votesJSON, err := json.Marshal(countVotes(poll, choices))
+		if err != nil {
+			return fmt.Errorf("repository: json marshal error: %w", err)
+		}
+		if _, err = tx.Exec(ctx, "UPDATE polls SET votes = $2 WHERE id = $1", pollID, votesJSON); err != nil {
+			return fmt.Errorf("repository: database update error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		r.l.Debug("failed to recount votes", zap.String("poll_id", pollID), zap.Error(err))
+	}
+	return err
+}
+
+func (r *PostgresPollRepository) selectVotes(query string, args ...interface{}) ([]models.Vote, error) {
+	rows, err := r.db.Query(context.Background(), query, args...)
+	if err != nil {
+		r.l.Debug("failed to select votes", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	votes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Vote, error) {
+		var vote models.Vote
+		err := row.Scan(&vote.PollID, &vote.UserID, &vote.ChoiceID)
+		return vote, err
+	})
+	if err != nil {
+		r.l.Debug("failed to select votes", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return votes, nil
+}
+
+func (r *PostgresPollRepository) exec(query string, args ...interface{}) error {
+	if _, err := r.db.Exec(context.Background(), query, args...); err != nil {
+		r.l.Debug("failed to update poll", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// update runs the statement changing one poll, the poll is not found if no row was changed
+func (r *PostgresPollRepository) update(query string, args ...interface{}) error {
+	tag, err := r.db.Exec(context.Background(), query, args...)
+	if err != nil {
+		r.l.Debug("failed to update poll", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrPollNotFound
+	}
+	return nil
+}
+
+// countVotes counts the chosen options of the poll, options without votes get zero
+// and choices of missing options are skipped
+func countVotes(poll *models.Poll, choices []string) map[string]int {
+	votes := make(map[string]int, len(poll.Options))
+	for _, option := range poll.Options {
+		votes[strconv.Itoa(option.ID)] = 0
+	}
+	for _, choice := range choices {
+		if _, ok := votes[choice]; ok {
+			votes[choice]++
+		}
+	}
+	return votes
+}
+
+// marshalPoll encodes options, vote counts and settings of the poll as JSON columns
+func marshalPoll(poll *models.Poll) (options, votes, settings []byte, err error) {
+	if options, err = json.Marshal(poll.Options); err != nil {
+		return nil, nil, nil, fmt.Errorf("repository: json marshal error: %w", err)
+	}
+	if votes, err = json.Marshal(poll.Votes); err != nil {
+		return nil, nil, nil, fmt.Errorf("repository: json marshal error: %w", err)
+	}
+	if settings, err = json.Marshal(poll.Settings); err != nil {
+		return nil, nil, nil, fmt.Errorf("repository: json marshal error: %w", err)
+	}
+	return options, votes, settings, nil
+}
+
+// scanPoll reads a row of pollColumns, no row means the poll is not found
+func scanPoll(row pgx.Row) (*models.Poll, error) {
+	poll := &models.Poll{}
+	var options, votes, settings []byte
+	err := row.Scan(&poll.ID, &poll.Question, &options, &votes, &poll.CreatorID, &poll.IsActive,
+		&poll.ChannelID, &settings, &poll.PostID, &poll.Reminded, &poll.RootID,
+		&poll.DeletedAt, &poll.EndedAt, &poll.Pinned, &poll.Anonymized)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.ErrPollNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	if err = unmarshalPoll(poll, options, votes, settings); err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// unmarshalPoll decodes JSON columns of the poll
+func unmarshalPoll(poll *models.Poll, options, votes, settings []byte) error {
+	if err := json.Unmarshal(options, &poll.Options); err != nil {
+		return fmt.Errorf("repository: failed to unmarshal options: %w", err)
+	}
+	poll.Votes = make(map[string]int)
+	if err := json.Unmarshal(votes, &poll.Votes); err != nil {
+		return fmt.Errorf("repository: failed to unmarshal votes: %w", err)
+	}
+	if len(settings) > 0 {
+		if err := json.Unmarshal(settings, &poll.Settings); err != nil {
+			return fmt.Errorf("repository: failed to unmarshal settings: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PostgresReminderRepository struct {
+	db *pgxpool.Pool
+	l  *zap.Logger
+}
+
+func NewPostgresReminderRepository(db *pgxpool.Pool, l *zap.Logger) *PostgresReminderRepository {
+	return &PostgresReminderRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+// Remindable returns the users who have not opted out and were last reminded before remindedBefore
+func (r *PostgresReminderRepository) Remindable(userIDs []string, remindedBefore time.Time) ([]string, error) {
+	rows, err := r.db.Query(context.Background(), `SELECT u.user_id FROM unnest($1::text[]) AS u (user_id)
+		WHERE NOT EXISTS (SELECT 1 FROM reminder_optouts o WHERE o.user_id = u.user_id)
+			AND NOT EXISTS (SELECT 1 FROM reminders r WHERE r.user_id = u.user_id AND r.sent_at >= $2)`,
+		userIDs, remindedBefore)
+	if err != nil {
+		r.l.Debug("failed to select remindable users", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	remindable, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		r.l.Debug("failed to select remindable users", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return remindable, nil
+}
+
+// SetOptOut turns reminders off or back on for the user
+func (r *PostgresReminderRepository) SetOptOut(userID string, optOut bool) error {
+	query := "DELETE FROM reminder_optouts WHERE user_id = $1"
+	if optOut {
+		query = "INSERT INTO reminder_optouts (user_id) VALUES ($1) ON CONFLICT DO NOTHING"
+	}
+	if _, err := r.db.Exec(context.Background(), query, userID); err != nil {
+		r.l.Debug("failed to update opt-out", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	r.l.Debug("updated reminder opt-out",
+		zap.String("user_id", userID),
+		zap.Bool("opt_out", optOut))
+	return nil
+}
+
+func (r *PostgresReminderRepository) SetReminded(userID string, sentAt time.Time) error {
+	_, err := r.db.Exec(context.Background(), `INSERT INTO reminders (user_id, sent_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET sent_at = excluded.sent_at`, userID, sentAt)
+	if err != nil {
+		r.l.Debug("failed to replace reminder", zap.Error(err))
+		return fmt.Errorf("repository: database replace error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PostgresWebhookRepository struct {
+	db *pgxpool.Pool
+	l  *zap.Logger
+}
+
+func NewPostgresWebhookRepository(db *pgxpool.Pool, l *zap.Logger) *PostgresWebhookRepository {
+	return &PostgresWebhookRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+func (r *PostgresWebhookRepository) Enqueue(delivery *models.Delivery) error {
+	_, err := r.db.Exec(context.Background(), `INSERT INTO webhook_deliveries
+		(id, url, event, payload, attempts, next_attempt_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		delivery.ID, delivery.URL, string(delivery.Event), delivery.Payload, delivery.Attempts, delivery.NextAttemptAt)
+	if err != nil {
+		r.l.Debug("failed to insert delivery", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// Due returns deliveries whose next attempt time has come
+func (r *PostgresWebhookRepository) Due(now time.Time, limit uint32) ([]*models.Delivery, error) {
+	rows, err := r.db.Query(context.Background(), `SELECT id, url, event, payload, attempts, next_attempt_at
+		FROM webhook_deliveries WHERE next_attempt_at <= $1 ORDER BY next_attempt_at LIMIT $2`, now, int64(limit))
+	if err != nil {
+		r.l.Debug("failed to select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.Delivery, error) {
+		delivery := &models.Delivery{}
+		err := row.Scan(&delivery.ID, &delivery.URL, &delivery.Event, &delivery.Payload, &delivery.Attempts,
+			&delivery.NextAttemptAt)
+		return delivery, err
+	})
+	if err != nil {
+		r.l.Debug("failed to select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *PostgresWebhookRepository) Reschedule(id string, attempts int, next time.Time) error {
+	_, err := r.db.Exec(context.Background(),
+		"UPDATE webhook_deliveries SET attempts = $2, next_attempt_at = $3 WHERE id = $1", id, attempts, next)
+	if err != nil {
+		r.l.Debug("failed to update delivery", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresWebhookRepository) Delete(id string) error {
+	_, err := r.db.Exec(context.Background(), "DELETE FROM webhook_deliveries WHERE id = $1", id)
+	if err != nil {
+		r.l.Debug("failed to delete delivery", zap.Error(err))
+		return fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return nil
+}
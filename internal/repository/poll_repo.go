@@ -6,16 +6,18 @@ import (
 	"github.com/jaam8/mattermost_bot/internal/models"
 	"github.com/tarantool/go-tarantool"
 	"go.uber.org/zap"
+	"math"
+	"time"
 )
 
 type PollRepository struct {
-	db *tarantool.Connection
+	db conn
 	l  *zap.Logger
 }
 
-func New(db *tarantool.Connection, l *zap.Logger) *PollRepository {
+func New(db tarantool.Connector, l *zap.Logger) *PollRepository {
 	return &PollRepository{
-		db: db,
+		db: conn{db},
 		l:  l,
 	}
 
@@ -29,6 +31,11 @@ func (r *PollRepository) CreatePoll(poll *models.Poll) (string, []models.Option,
 		r.l.Debug("error marshalling votes", zap.Error(err))
 		return "", nil, fmt.Errorf("repository: json marshal error: %w", err)
 	}
+	settingsJSON, err := json.Marshal(poll.Settings)
+	if err != nil {
+		r.l.Debug("error marshalling settings", zap.Error(err))
+		return "", nil, fmt.Errorf("repository: json marshal error: %w", err)
+	}
 
 	pollReq := []interface{}{
 		poll.ID,
@@ -37,6 +44,11 @@ func (r *PollRepository) CreatePoll(poll *models.Poll) (string, []models.Option,
 		string(votesJSON),
 		poll.CreatorID,
 		poll.IsActive,
+		poll.ChannelID,
+		string(settingsJSON),
+		poll.PostID,
+		poll.Reminded,
+		poll.RootID,
 	}
 
 	resp, err := r.db.Insert("polls", pollReq)
@@ -52,98 +64,103 @@ func (r *PollRepository) CreatePoll(poll *models.Poll) (string, []models.Option,
 	return poll.ID, poll.Options, nil
 }
 
+// Vote records the vote with cast_vote function from init.lua, which inserts the vote
+// and increments the poll count in one transaction
 func (r *PollRepository) Vote(pollID, choiceID, userID string) error {
-	pollTuple, err := r.GetPoll(pollID)
+	poll, err := r.activePoll(pollID, choiceID)
 	if err != nil {
 		return err
 	}
-	votesField, ok := pollTuple[3].(string)
-	if !ok {
-		r.l.Debug("unexpected type for votes field", zap.Any("votes_field", pollTuple[3]))
-		return models.ErrFailedToProcessData
-	}
-	isActive, ok := pollTuple[5].(bool)
-	if !isActive {
-		r.l.Debug("poll is not active", zap.String("poll_id", pollID))
-		return models.ErrPollIsEnd
-	}
-	var votes map[string]int
-	err = json.Unmarshal([]byte(votesField), &votes)
+	maxChoices := poll.Settings.Choices()
+	status, err := r.callStatus("cast_vote", pollID, userID, choiceID, maxChoices)
 	if err != nil {
-		r.l.Debug("failed to unmarshal votes", zap.Error(err))
-		return fmt.Errorf("repository: failed to unmarshal votes: %w", err)
+		return err
 	}
-	if _, ok := votes[choiceID]; !ok {
-		r.l.Debug("option not found", zap.String("choice_id", choiceID))
-		return models.ErrOptionIsNotFound
+	if status == "limit" {
+		// the only choice of single choice polls is already taken
+		if maxChoices == 1 {
+			return models.ErrVoteAlreadyExists
+		}
+		return models.ErrTooManyChoices
 	}
-	if err = r.InsertVote(pollID, userID, choiceID); err != nil {
-		r.l.Debug("failed to insert vote", zap.Error(err))
+	return voteStatusError(status)
+}
+
+// Unvote removes the user vote for the choice from an active poll with retract_vote function
+// from init.lua, which deletes the vote and decrements the poll count in one transaction
+func (r *PollRepository) Unvote(pollID, choiceID, userID string) error {
+	if _, err := r.activePoll(pollID, choiceID); err != nil {
 		return err
 	}
-	votes[choiceID]++
-	r.l.Debug("updated votes", zap.Any("votes", votes))
-
-	updatedVotesJSON, err := json.Marshal(votes)
+	status, err := r.callStatus("retract_vote", pollID, userID, choiceID)
 	if err != nil {
-		return fmt.Errorf("json marshal error: %w", err)
+		return err
 	}
-	updateVotes := []interface{}{[]interface{}{"=", 3, string(updatedVotesJSON)}}
+	return voteStatusError(status)
+}
 
-	resp, err := r.db.Update("polls", "primary", []interface{}{pollID}, updateVotes)
-	r.l.Debug("tarantool response",
-		zap.Uint32("status_code", resp.Code),
-		zap.Any("resp", resp.Data))
+// activePoll returns the poll if it accepts votes for the choice
+func (r *PollRepository) activePoll(pollID, choiceID string) (*models.Poll, error) {
+	pollTuple, err := r.GetPoll(pollID)
 	if err != nil {
-		r.l.Debug("failed to update votes", zap.Error(err))
-		return fmt.Errorf("repository: database update error: %w", err)
+		return nil, err
 	}
-	return nil
-}
-
-// InsertVote inserts a vote into the votes space if user has not already voted
-func (r *PollRepository) InsertVote(pollID, userID, choiceID string) error {
-	existenceVote, err := r.db.Select("votes", "user_poll", 0, 1, tarantool.IterEq, []interface{}{pollID, userID})
+	poll, err := r.pollFromTuple(pollTuple)
 	if err != nil {
-		r.l.Debug("failed to select vote", zap.Error(err))
-		return fmt.Errorf("repository: database select error: %w", err)
+		return nil, err
 	}
-	r.l.Debug("tarantool response",
-		zap.Uint32("status_code", existenceVote.Code),
-		zap.Any("resp", existenceVote.Data),
-		zap.Any("error", existenceVote.Error))
-	if len(existenceVote.Data) > 0 {
-		r.l.Debug("vote already exist",
-			zap.String("poll_id", pollID),
-			zap.String("user_id", userID))
-		return models.ErrVoteAlreadyExists
+	if !poll.IsActive || poll.Settings.Deadline != nil && !time.Now().Before(*poll.Settings.Deadline) {
+		r.l.Debug("poll is not active", zap.String("poll_id", pollID))
+		return nil, models.ErrPollIsEnd
 	}
-	vote := &models.Vote{
-		PollID:   pollID,
-		UserID:   userID,
-		ChoiceID: choiceID,
+	if _, ok := poll.Votes[choiceID]; !ok {
+		r.l.Debug("option not found", zap.String("choice_id", choiceID))
+		return nil, models.ErrOptionIsNotFound
 	}
-	resp, err := r.db.Insert("votes", []interface{}{vote.PollID, vote.UserID, vote.ChoiceID})
-	if err != nil {
-		r.l.Debug("failed to insert vote", zap.Error(err))
-		return fmt.Errorf("repository: database insert error: %w", err)
+	return poll, nil
+}
+
+// voteStatusError maps statuses of cast_vote and retract_vote to models errors
+func voteStatusError(status string) error {
+	switch status {
+	case "ok":
+		return nil
+	case "not_found":
+		return models.ErrPollNotFound
+	case "ended":
+		return models.ErrPollIsEnd
+	case "exists":
+		return models.ErrVoteAlreadyExists
+	case "no_vote":
+		return models.ErrVoteNotFound
+	default:
+		return models.ErrFailedToProcessData
 	}
-	r.l.Debug("tarantool response",
-		zap.Uint32("status_code", resp.Code),
-		zap.Any("resp", resp.Data),
-		zap.Any("error", resp.Error))
-	return nil
 }
 
 func (r *PollRepository) GetPollResult(pollID string) (*models.Poll, error) {
 	pollTuple, err := r.GetPoll(pollID)
-	poll := &models.Poll{}
 	if err != nil {
-		return poll, err
+		return &models.Poll{}, err
 	}
 	r.l.Debug("tarantool response", zap.Any("result", pollTuple))
-	poll.ID = pollTuple[0].(string)
-	poll.Question = pollTuple[1].(string)
+	poll, err := r.pollFromTuple(pollTuple)
+	if err != nil {
+		return nil, err
+	}
+	r.l.Debug("poll data from tarantool", zap.Any("poll", poll))
+	return poll, nil
+}
+
+// pollFromTuple decodes polls space tuple, fields added by later migrations are optional
+func (r *PollRepository) pollFromTuple(pollTuple []interface{}) (*models.Poll, error) {
+	if len(pollTuple) < 6 {
+		r.l.Debug("unexpected poll tuple length", zap.Int("len", len(pollTuple)))
+		return nil, models.ErrFailedToProcessData
+	}
+	poll := &models.Poll{}
+	poll.ID, _ = pollTuple[0].(string)
+	poll.Question, _ = pollTuple[1].(string)
 	optionsRaw, ok := pollTuple[2].([]interface{})
 	if !ok {
 		return nil, fmt.Errorf("repository: unexpected type for pollTuple options: %w",
@@ -164,17 +181,55 @@ func (r *PollRepository) GetPollResult(pollID string) (*models.Poll, error) {
 		}
 		options = append(options, option)
 	}
-	r.l.Debug("options", zap.Any("options", options))
 	poll.Options = options
 	poll.Votes = make(map[string]int)
-	err = json.Unmarshal([]byte(pollTuple[3].(string)), &poll.Votes)
-	if err != nil {
+	votesField, ok := pollTuple[3].(string)
+	if !ok {
+		r.l.Debug("unexpected type for votes field", zap.Any("votes_field", pollTuple[3]))
+		return nil, models.ErrFailedToProcessData
+	}
+	if err := json.Unmarshal([]byte(votesField), &poll.Votes); err != nil {
 		r.l.Debug("failed to unmarshal votes", zap.Error(err))
 		return nil, fmt.Errorf("repository: failed to unmarshal votes: %w", err)
 	}
-	poll.CreatorID = pollTuple[4].(string)
-	poll.IsActive = pollTuple[5].(bool)
-	r.l.Debug("poll data from tarantool", zap.Any("poll", poll))
+	poll.CreatorID, _ = pollTuple[4].(string)
+	poll.IsActive, _ = pollTuple[5].(bool)
+	if len(pollTuple) > 6 {
+		poll.ChannelID, _ = pollTuple[6].(string)
+	}
+	if len(pollTuple) > 7 {
+		if settingsField, ok := pollTuple[7].(string); ok && settingsField != "" {
+			if err := json.Unmarshal([]byte(settingsField), &poll.Settings); err != nil {
+				r.l.Debug("failed to unmarshal settings", zap.Error(err))
+				return nil, fmt.Errorf("repository: failed to unmarshal settings: %w", err)
+			}
+		}
+	}
+	if len(pollTuple) > 8 {
+		poll.PostID, _ = pollTuple[8].(string)
+	}
+	if len(pollTuple) > 9 {
+		poll.Reminded, _ = pollTuple[9].(bool)
+	}
+	if len(pollTuple) > 10 {
+		poll.RootID, _ = pollTuple[10].(string)
+	}
+	if deletedAt := tupleDeletedAt(pollTuple); deletedAt != 0 {
+		t := time.Unix(deletedAt, 0)
+		poll.DeletedAt = &t
+	}
+	if len(pollTuple) > endedAtField {
+		if endedAt, ok := toInt64(pollTuple[endedAtField]); ok && endedAt != 0 {
+			t := time.Unix(endedAt, 0)
+			poll.EndedAt = &t
+		}
+	}
+	if len(pollTuple) > pinnedField {
+		poll.Pinned, _ = pollTuple[pinnedField].(bool)
+	}
+	if len(pollTuple) > anonymizedField {
+		poll.Anonymized, _ = pollTuple[anonymizedField].(bool)
+	}
 	return poll, nil
 }
 
@@ -196,7 +251,8 @@ func convertKeys(i interface{}) interface{} {
 	}
 }
 
-func (r *PollRepository) DeletePoll(pollID, userID string) error {
+// DeletePoll moves the poll to trash, it is hidden from all reads until restored or purged
+func (r *PollRepository) DeletePoll(pollID, userID string, now time.Time) error {
 	pollTuple, err := r.GetPoll(pollID)
 	if err != nil {
 		return err
@@ -205,10 +261,11 @@ func (r *PollRepository) DeletePoll(pollID, userID string) error {
 		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
 		return models.ErrUserNotOwner
 	}
-	resp, err := r.db.Delete("polls", "primary", []interface{}{pollID})
+	resp, err := r.db.Update("polls", "primary", []interface{}{pollID},
+		setField(pollTuple, deletedAtField, uint64(now.Unix())))
 	if err != nil {
 		r.l.Debug("failed to delete poll", zap.Error(err))
-		return fmt.Errorf("repository: database delete error: %w", err)
+		return fmt.Errorf("repository: database update error: %w", err)
 	}
 	r.l.Debug("tarantool response",
 		zap.Uint32("status_code", resp.Code),
@@ -230,21 +287,150 @@ func (r *PollRepository) EndPoll(pollID, userID string) error {
 		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
 		return models.ErrUserNotOwner
 	}
-	resp, err := r.db.Update("polls", "primary",
-		[]interface{}{pollID},
-		[]interface{}{[]interface{}{"=", 5, false}})
+	return r.Deactivate(pollID)
+}
+
+// Deactivate ends the active poll without checking its owner with end_poll function from init.lua,
+// an ended poll is not changed and ErrPollAlreadyEnded is returned, so only one of concurrent calls ends it
+func (r *PollRepository) Deactivate(pollID string) error {
+	status, err := r.callStatus("end_poll", pollID, uint64(time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+	switch status {
+	case "ok":
+		return nil
+	case "not_found":
+		return models.ErrPollNotFound
+	case "ended":
+		return models.ErrPollAlreadyEnded
+	default:
+		return models.ErrFailedToProcessData
+	}
+}
+
+// callStatus calls the function of init.lua which returns a status
+func (r *PollRepository) callStatus(function string, args ...interface{}) (string, error) {
+	resp, err := r.db.Call17(function, args)
 	if err != nil {
-		r.l.Debug("failed to update poll", zap.Error(err))
+		r.l.Debug("failed to call function", zap.String("function", function), zap.Error(err))
+		return "", fmt.Errorf("repository: database call error: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return "", models.ErrFailedToProcessData
+	}
+	status, _ := resp.Data[0].(string)
+	r.l.Debug("function result",
+		zap.String("function", function),
+		zap.Any("args", args),
+		zap.String("status", status))
+	return status, nil
+}
+
+// UndeletePoll restores the poll deleted after notBefore
+func (r *PollRepository) UndeletePoll(pollID, userID string, notBefore time.Time) error {
+	pollTuple, err := r.getPollTuple(pollID)
+	if err != nil {
+		return err
+	}
+	deletedAt := tupleDeletedAt(pollTuple)
+	if deletedAt == 0 {
+		return models.ErrPollNotDeleted
+	}
+	if pollTuple[4].(string) != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	if time.Unix(deletedAt, 0).Before(notBefore) {
+		return models.ErrUndeleteExpired
+	}
+	_, err = r.db.Update("polls", "primary", []interface{}{pollID},
+		[]interface{}{[]interface{}{"=", deletedAtField, nil}})
+	if err != nil {
+		r.l.Debug("failed to undelete poll", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// DeletedBefore returns ids of up to limit polls deleted before t
+func (r *PollRepository) DeletedBefore(t time.Time, limit uint32) ([]string, error) {
+	resp, err := r.db.Select("polls", "deleted", 0, limit, tarantool.IterGt, []interface{}{uint64(0)})
+	if err != nil {
+		r.l.Debug("failed to select deleted polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	var ids []string
+	for _, data := range resp.Data {
+		pollTuple, ok := data.([]interface{})
+		if !ok || len(pollTuple) < 1 {
+			r.l.Debug("unexpected data type", zap.Any("data", data))
+			return nil, models.ErrFailedToProcessData
+		}
+		// the index is ordered by deletion time, so the rest is deleted later
+		if !time.Unix(tupleDeletedAt(pollTuple), 0).Before(t) {
+			break
+		}
+		id, _ := pollTuple[0].(string)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SetEndedAt sets the time the poll was ended, it is used for polls ended before the time was stored
+func (r *PollRepository) SetEndedAt(pollID string, endedAt time.Time) error {
+	return r.setPollField(pollID, endedAtField, uint64(endedAt.Unix()))
+}
+
+// SetPinned pins the poll so that the retention policy keeps it or releases the pin
+func (r *PollRepository) SetPinned(pollID string, pinned bool) error {
+	return r.setPollField(pollID, pinnedField, pinned)
+}
+
+func (r *PollRepository) setPollField(pollID string, field int, value interface{}) error {
+	pollTuple, err := r.GetPoll(pollID)
+	if err != nil {
+		return err
+	}
+	if _, err = r.db.Update("polls", "primary", []interface{}{pollID}, setField(pollTuple, field, value)); err != nil {
+		r.l.Debug("failed to update poll", zap.Int("field", field), zap.Error(err))
 		return fmt.Errorf("repository: database update error: %w", err)
 	}
-	r.l.Debug("tarantool response",
-		zap.Uint32("status_code", resp.Code),
-		zap.Any("resp", resp.Data),
-		zap.String("error", resp.Error))
 	return nil
 }
 
+// AnonymizeVotes replaces voter ids of the poll and marks it as anonymized in one transaction
+func (r *PollRepository) AnonymizeVotes(pollID string) error {
+	if _, err := r.db.Call17("anonymize_votes", []interface{}{pollID}); err != nil {
+		r.l.Debug("failed to anonymize votes", zap.Error(err))
+		return fmt.Errorf("repository: database call error: %w", err)
+	}
+	return nil
+}
+
+// PurgePoll removes the poll and all its votes in one transaction
+func (r *PollRepository) PurgePoll(pollID string) error {
+	if _, err := r.db.Call17("purge_poll", []interface{}{pollID}); err != nil {
+		r.l.Debug("failed to purge poll", zap.Error(err))
+		return fmt.Errorf("repository: database call error: %w", err)
+	}
+	return nil
+}
+
+// GetPoll returns the poll tuple, deleted polls are not found
 func (r *PollRepository) GetPoll(pollID string) ([]interface{}, error) {
+	pollTuple, err := r.getPollTuple(pollID)
+	if err != nil {
+		return pollTuple, err
+	}
+	if tupleDeletedAt(pollTuple) != 0 {
+		r.l.Debug("poll is deleted", zap.String("poll_id", pollID))
+		return []interface{}{}, models.ErrPollNotFound
+	}
+	return pollTuple, nil
+}
+
+func (r *PollRepository) getPollTuple(pollID string) ([]interface{}, error) {
 	existencePoll, err := r.db.Select("polls", "primary", 0, 1, tarantool.IterEq, []interface{}{pollID})
 	if err != nil {
 		r.l.Debug("failed to select poll", zap.Error(err))
@@ -266,3 +452,204 @@ func (r *PollRepository) GetPoll(pollID string) ([]interface{}, error) {
 	}
 	return pollTuple, nil
 }
+
+// GetPollByPost returns the poll announced in the post
+func (r *PollRepository) GetPollByPost(postID string) (*models.Poll, error) {
+	resp, err := r.db.Select("polls", "post", 0, 1, tarantool.IterEq, []interface{}{postID})
+	if err != nil {
+		r.l.Debug("failed to select poll by post", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, models.ErrPollNotFound
+	}
+	pollTuple, ok := resp.Data[0].([]interface{})
+	if !ok {
+		r.l.Debug("unexpected data type", zap.Any("data", resp.Data))
+		return nil, models.ErrFailedToProcessData
+	}
+	if tupleDeletedAt(pollTuple) != 0 {
+		return nil, models.ErrPollNotFound
+	}
+	return r.pollFromTuple(pollTuple)
+}
+
+func (r *PollRepository) SetPostID(pollID, postID, rootID string) error {
+	resp, err := r.db.Update("polls", "primary",
+		[]interface{}{pollID},
+		[]interface{}{[]interface{}{"=", 8, postID}, []interface{}{"=", 10, rootID}})
+	if err != nil {
+		r.l.Debug("failed to update poll post id", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	r.l.Debug("tarantool response",
+		zap.Uint32("status_code", resp.Code),
+		zap.Any("resp", resp.Data),
+		zap.String("error", resp.Error))
+	return nil
+}
+
+func (r *PollRepository) SetReminded(pollID string) error {
+	resp, err := r.db.Update("polls", "primary",
+		[]interface{}{pollID},
+		[]interface{}{[]interface{}{"=", 9, true}})
+	if err != nil {
+		r.l.Debug("failed to update poll reminded flag", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	r.l.Debug("tarantool response",
+		zap.Uint32("status_code", resp.Code),
+		zap.Any("resp", resp.Data),
+		zap.String("error", resp.Error))
+	return nil
+}
+
+// GetVoters returns ids of users who voted in the poll
+func (r *PollRepository) GetVoters(pollID string) ([]string, error) {
+	votes, err := r.GetVotes(pollID)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVoters(votes), nil
+}
+
+// uniqueVoters returns ids of the voters in the order of their first vote
+func uniqueVoters(votes []models.Vote) []string {
+	voters := make([]string, 0, len(votes))
+	seen := make(map[string]bool, len(votes))
+	for _, vote := range votes {
+		if seen[vote.UserID] {
+			continue
+		}
+		seen[vote.UserID] = true
+		voters = append(voters, vote.UserID)
+	}
+	return voters
+}
+
+func (r *PollRepository) GetVotes(pollID string) ([]models.Vote, error) {
+	return r.selectVotes(tarantool.IterEq, []interface{}{pollID})
+}
+
+// ListAllVotes returns rows of the votes space of all polls
+func (r *PollRepository) ListAllVotes() ([]models.Vote, error) {
+	return r.selectVotes(tarantool.IterAll, []interface{}{})
+}
+
+// DeleteVote removes the vote row without changing the poll counts
+func (r *PollRepository) DeleteVote(vote models.Vote) error {
+	if _, err := r.db.Delete("votes", "primary", []interface{}{vote.PollID, vote.UserID, vote.ChoiceID}); err != nil {
+		r.l.Debug("failed to delete vote", zap.Error(err))
+		return fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return nil
+}
+
+// RecountVotes sets the vote counts of the poll to the numbers of its votes,
+// recount_votes function of init.lua does it in one transaction
+func (r *PollRepository) RecountVotes(pollID string) error {
+	status, err := r.callStatus("recount_votes", pollID)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case "ok":
+		return nil
+	case "not_found":
+		return models.ErrPollNotFound
+	default:
+		return models.ErrFailedToProcessData
+	}
+}
+
+func (r *PollRepository) selectVotes(iter uint32, key []interface{}) ([]models.Vote, error) {
+	resp, err := r.db.Select("votes", "poll", 0, math.MaxUint32, iter, key)
+	if err != nil {
+		r.l.Debug("failed to select votes", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	r.l.Debug("tarantool response",
+		zap.Uint32("status_code", resp.Code),
+		zap.Int("votes", len(resp.Data)),
+		zap.String("error", resp.Error))
+	votes := make([]models.Vote, 0, len(resp.Data))
+	for _, data := range resp.Data {
+		voteTuple, ok := data.([]interface{})
+		if !ok || len(voteTuple) < 3 {
+			r.l.Debug("unexpected data type", zap.Any("data", data))
+			return nil, models.ErrFailedToProcessData
+		}
+		vote := models.Vote{}
+		vote.PollID, _ = voteTuple[0].(string)
+		vote.UserID, _ = voteTuple[1].(string)
+		vote.ChoiceID, _ = voteTuple[2].(string)
+		votes = append(votes, vote)
+	}
+	return votes, nil
+}
+
+// ListPolls returns polls which are not deleted
+func (r *PollRepository) ListPolls() ([]*models.Poll, error) {
+	return r.listPolls(false)
+}
+
+// ListAllPolls returns all polls including deleted ones
+func (r *PollRepository) ListAllPolls() ([]*models.Poll, error) {
+	return r.listPolls(true)
+}
+
+func (r *PollRepository) listPolls(withDeleted bool) ([]*models.Poll, error) {
+	resp, err := r.db.Select("polls", "primary", 0, math.MaxUint32, tarantool.IterAll, []interface{}{})
+	if err != nil {
+		r.l.Debug("failed to select polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	r.l.Debug("tarantool response",
+		zap.Uint32("status_code", resp.Code),
+		zap.Int("polls", len(resp.Data)),
+		zap.String("error", resp.Error))
+	polls := make([]*models.Poll, 0, len(resp.Data))
+	for _, data := range resp.Data {
+		pollTuple, ok := data.([]interface{})
+		if !ok {
+			r.l.Debug("unexpected data type", zap.Any("data", data))
+			return nil, models.ErrFailedToProcessData
+		}
+		if !withDeleted && tupleDeletedAt(pollTuple) != 0 {
+			continue
+		}
+		poll, err := r.pollFromTuple(pollTuple)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, poll)
+	}
+	return polls, nil
+}
+
+// numbers of the polls fields added after the tuple was decoded by position
+const (
+	deletedAtField  = 11
+	endedAtField    = 12
+	pinnedField     = 13
+	anonymizedField = 14
+)
+
+// tupleDeletedAt returns unix time the poll was deleted at, zero if it is not deleted
+func tupleDeletedAt(pollTuple []interface{}) int64 {
+	if len(pollTuple) <= deletedAtField {
+		return 0
+	}
+	deletedAt, _ := toInt64(pollTuple[deletedAtField])
+	return deletedAt
+}
+
+// setField returns update operations setting the field, tuples written before the field
+// was added are padded with nulls since update can only append the next field
+func setField(tuple []interface{}, field int, value interface{}) []interface{} {
+	var ops []interface{}
+	for i := len(tuple); i < field; i++ {
+		ops = append(ops, []interface{}{"=", i, nil})
+	}
+	return append(ops, []interface{}{"=", field, value})
+}
@@ -0,0 +1,231 @@
+package repository_test
+
+import (
+	"errors"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/jaam8/mattermost_bot/internal/service"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPollStoreTarantool(t *testing.T) {
+	testPollStore(t, tarantoolBackend(t).polls)
+}
+
+func TestPollStorePostgres(t *testing.T) {
+	testPollStore(t, postgresBackend(t).polls)
+}
+
+// TestPollStoreSQLite needs no database server, so it always runs
+func TestPollStoreSQLite(t *testing.T) {
+	testPollStore(t, sqliteBackend(t).polls)
+}
+
+// testPollStore checks the behaviour every PollStore backend shares, each case uses its own poll
+func testPollStore(t *testing.T, store service.PollStore) {
+	create := func(t *testing.T, options int, settings models.Settings) *models.Poll {
+		t.Helper()
+		poll := newTestPoll(options, settings)
+		if _, _, err := store.CreatePoll(poll); err != nil {
+			t.Fatalf("CreatePoll: %v", err)
+		}
+		return poll
+	}
+	vote := func(t *testing.T, pollID, choiceID, userID string) {
+		t.Helper()
+		if err := store.Vote(pollID, choiceID, userID); err != nil {
+			t.Fatalf("Vote(%s, %s): %v", choiceID, userID, err)
+		}
+	}
+	get := func(t *testing.T, pollID string) *models.Poll {
+		t.Helper()
+		poll, err := store.GetPollResult(pollID)
+		if err != nil {
+			t.Fatalf("GetPollResult: %v", err)
+		}
+		return poll
+	}
+	wantErr := func(t *testing.T, op string, err, want error) {
+		t.Helper()
+		if !errors.Is(err, want) {
+			t.Errorf("%s error = %v, want %v", op, err, want)
+		}
+	}
+
+	t.Run("create", func(t *testing.T) {
+		poll := newTestPoll(3, models.Settings{MaxChoices: 2})
+		id, options, err := store.CreatePoll(poll)
+		if err != nil {
+			t.Fatalf("CreatePoll: %v", err)
+		}
+		if id != poll.ID || !reflect.DeepEqual(options, poll.Options) {
+			t.Errorf("CreatePoll = %s, %v, want %s, %v", id, options, poll.ID, poll.Options)
+		}
+		got := get(t, poll.ID)
+		if got.Question != poll.Question || got.CreatorID != poll.CreatorID || got.ChannelID != poll.ChannelID ||
+			!got.IsActive || got.Settings.MaxChoices != 2 {
+			t.Errorf("GetPollResult = %+v, want %+v", got, poll)
+		}
+		if !reflect.DeepEqual(got.Options, poll.Options) || !reflect.DeepEqual(got.Votes, poll.Votes) {
+			t.Errorf("options and votes = %v, %v, want %v, %v", got.Options, got.Votes, poll.Options, poll.Votes)
+		}
+		_, err = store.GetPollResult("missing")
+		wantErr(t, "GetPollResult of a missing poll", err, models.ErrPollNotFound)
+	})
+
+	t.Run("vote", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		vote(t, poll.ID, "2", "voter")
+		if got := get(t, poll.ID).Votes; got["1"] != 0 || got["2"] != 1 {
+			t.Errorf("votes = %v, want one vote for 2", got)
+		}
+		votes, err := store.GetVotes(poll.ID)
+		if err != nil {
+			t.Fatalf("GetVotes: %v", err)
+		}
+		want := []models.Vote{{PollID: poll.ID, UserID: "voter", ChoiceID: "2"}}
+		if !reflect.DeepEqual(votes, want) {
+			t.Errorf("GetVotes = %v, want %v", votes, want)
+		}
+		wantErr(t, "Vote for a missing option", store.Vote(poll.ID, "3", "other"), models.ErrOptionIsNotFound)
+		wantErr(t, "Vote in a missing poll", store.Vote("missing", "1", "other"), models.ErrPollNotFound)
+	})
+
+	t.Run("duplicate vote", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		vote(t, poll.ID, "1", "voter")
+		wantErr(t, "second Vote for the same option", store.Vote(poll.ID, "1", "voter"), models.ErrVoteAlreadyExists)
+		wantErr(t, "Vote for another option", store.Vote(poll.ID, "2", "voter"), models.ErrVoteAlreadyExists)
+		if got := get(t, poll.ID).Votes; got["1"] != 1 || got["2"] != 0 {
+			t.Errorf("votes = %v, want one vote for 1", got)
+		}
+	})
+
+	t.Run("max choices", func(t *testing.T) {
+		poll := create(t, 3, models.Settings{MaxChoices: 2})
+		vote(t, poll.ID, "1", "voter")
+		vote(t, poll.ID, "2", "voter")
+		wantErr(t, "Vote over max choices", store.Vote(poll.ID, "3", "voter"), models.ErrTooManyChoices)
+		wantErr(t, "second Vote for the same option", store.Vote(poll.ID, "2", "voter"), models.ErrVoteAlreadyExists)
+		vote(t, poll.ID, "3", "other")
+		if got := get(t, poll.ID).Votes; got["1"] != 1 || got["2"] != 1 || got["3"] != 1 {
+			t.Errorf("votes = %v, want one vote for every option", got)
+		}
+	})
+
+	t.Run("unvote", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		vote(t, poll.ID, "1", "voter")
+		if err := store.Unvote(poll.ID, "1", "voter"); err != nil {
+			t.Fatalf("Unvote: %v", err)
+		}
+		if got := get(t, poll.ID).Votes; got["1"] != 0 {
+			t.Errorf("votes = %v, want no votes", got)
+		}
+		voters, err := store.GetVoters(poll.ID)
+		if err != nil {
+			t.Fatalf("GetVoters: %v", err)
+		}
+		if len(voters) != 0 {
+			t.Errorf("GetVoters = %v, want none", voters)
+		}
+		wantErr(t, "second Unvote", store.Unvote(poll.ID, "1", "voter"), models.ErrVoteNotFound)
+		vote(t, poll.ID, "2", "voter")
+	})
+
+	t.Run("end", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		vote(t, poll.ID, "1", "voter")
+		if err := store.EndPoll(poll.ID, poll.CreatorID); err != nil {
+			t.Fatalf("EndPoll: %v", err)
+		}
+		got := get(t, poll.ID)
+		if got.IsActive || got.EndedAt == nil {
+			t.Errorf("ended poll is_active = %v, ended_at = %v", got.IsActive, got.EndedAt)
+		}
+		wantErr(t, "Vote in an ended poll", store.Vote(poll.ID, "2", "other"), models.ErrPollIsEnd)
+		wantErr(t, "Unvote in an ended poll", store.Unvote(poll.ID, "1", "voter"), models.ErrPollIsEnd)
+		wantErr(t, "second EndPoll", store.EndPoll(poll.ID, poll.CreatorID), models.ErrPollAlreadyEnded)
+		wantErr(t, "Deactivate of an ended poll", store.Deactivate(poll.ID), models.ErrPollAlreadyEnded)
+		wantErr(t, "Deactivate of a missing poll", store.Deactivate("missing"), models.ErrPollNotFound)
+	})
+
+	t.Run("non-owner end", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		wantErr(t, "EndPoll by another user", store.EndPoll(poll.ID, "other"), models.ErrUserNotOwner)
+		if !get(t, poll.ID).IsActive {
+			t.Error("poll was ended by another user")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		now := time.Now()
+		wantErr(t, "DeletePoll by another user", store.DeletePoll(poll.ID, "other", now), models.ErrUserNotOwner)
+		if err := store.DeletePoll(poll.ID, poll.CreatorID, now); err != nil {
+			t.Fatalf("DeletePoll: %v", err)
+		}
+		_, err := store.GetPollResult(poll.ID)
+		wantErr(t, "GetPollResult of a deleted poll", err, models.ErrPollNotFound)
+		wantErr(t, "Vote in a deleted poll", store.Vote(poll.ID, "1", "voter"), models.ErrPollNotFound)
+		polls, err := store.ListPolls()
+		if err != nil {
+			t.Fatalf("ListPolls: %v", err)
+		}
+		if slices.ContainsFunc(polls, func(p *models.Poll) bool { return p.ID == poll.ID }) {
+			t.Error("ListPolls returned a deleted poll")
+		}
+	})
+
+	t.Run("undelete", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{})
+		now := time.Now()
+		wantErr(t, "UndeletePoll of a poll which is not deleted",
+			store.UndeletePoll(poll.ID, poll.CreatorID, now.Add(-time.Hour)), models.ErrPollNotDeleted)
+		if err := store.DeletePoll(poll.ID, poll.CreatorID, now); err != nil {
+			t.Fatalf("DeletePoll: %v", err)
+		}
+		wantErr(t, "UndeletePoll by another user",
+			store.UndeletePoll(poll.ID, "other", now.Add(-time.Hour)), models.ErrUserNotOwner)
+		wantErr(t, "UndeletePoll after the window",
+			store.UndeletePoll(poll.ID, poll.CreatorID, now.Add(time.Hour)), models.ErrUndeleteExpired)
+		if err := store.UndeletePoll(poll.ID, poll.CreatorID, now.Add(-time.Hour)); err != nil {
+			t.Fatalf("UndeletePoll: %v", err)
+		}
+		if got := get(t, poll.ID); got.DeletedAt != nil {
+			t.Errorf("undeleted poll deleted_at = %v", got.DeletedAt)
+		}
+		vote(t, poll.ID, "1", "voter")
+	})
+
+	t.Run("anonymize", func(t *testing.T) {
+		poll := create(t, 2, models.Settings{MaxChoices: 2})
+		vote(t, poll.ID, "1", "alice")
+		vote(t, poll.ID, "2", "alice")
+		vote(t, poll.ID, "1", "bob")
+		if err := store.EndPoll(poll.ID, poll.CreatorID); err != nil {
+			t.Fatalf("EndPoll: %v", err)
+		}
+		if err := store.AnonymizeVotes(poll.ID); err != nil {
+			t.Fatalf("AnonymizeVotes: %v", err)
+		}
+		got := get(t, poll.ID)
+		if !got.Anonymized || got.Votes["1"] != 2 || got.Votes["2"] != 1 {
+			t.Errorf("anonymized = %v, votes = %v, want counts kept", got.Anonymized, got.Votes)
+		}
+		votes, err := store.GetVotes(poll.ID)
+		if err != nil {
+			t.Fatalf("GetVotes: %v", err)
+		}
+		choices := map[string]int{}
+		for _, vote := range votes {
+			choices[vote.UserID]++
+		}
+		anon1, anon2 := models.AnonymousVoter+"1", models.AnonymousVoter+"2"
+		if len(choices) != 2 || choices[anon1]+choices[anon2] != 3 || choices[anon1]*choices[anon2] != 2 {
+			t.Errorf("votes after anonymization = %v, want two anonymous voters with 2 and 1 choices", votes)
+		}
+	})
+}
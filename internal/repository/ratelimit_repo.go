@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often buckets which have refilled are deleted from Tarantool
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitSweepBatch limits buckets deleted by one call of rate_limit_sweep
+const rateLimitSweepBatch = 1000
+
+// RateLimitRepository keeps token buckets in Tarantool so they are shared between bot replicas,
+// buckets which have refilled are deleted so the space does not grow with the number of users
+type RateLimitRepository struct {
+	db conn
+	l  *zap.Logger
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewRateLimitRepository(db tarantool.Connector, l *zap.Logger) *RateLimitRepository {
+	return &RateLimitRepository{
+		db: conn{db},
+		l:  l,
+	}
+}
+
+// Take refills the buckets and takes one token from each of them if all have one, the refill
+// is done by rate_limit_take function from init.lua so it is atomic across replicas
+func (r *RateLimitRepository) Take(buckets []models.Bucket, now time.Time) (bool, error) {
+	r.sweep(now)
+	keys := make([]interface{}, len(buckets))
+	rates := make([]interface{}, len(buckets))
+	bursts := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		keys[i], rates[i], bursts[i] = bucket.Key, bucket.Rate, bucket.Burst
+	}
+	resp, err := r.db.Call17("rate_limit_take", []interface{}{keys, rates, bursts, now.UnixMilli()})
+	if err != nil {
+		r.l.Debug("failed to take token", zap.Any("keys", keys), zap.Error(err))
+		return false, fmt.Errorf("repository: database call error: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return false, models.ErrFailedToProcessData
+	}
+	allowed, ok := resp.Data[0].(bool)
+	if !ok {
+		return false, models.ErrFailedToProcessData
+	}
+	return allowed, nil
+}
+
+// sweep deletes buckets which have refilled by now once per rateLimitSweepInterval,
+// a missing bucket is the same as a full one
+func (r *RateLimitRepository) sweep(now time.Time) {
+	r.mu.Lock()
+	if now.Sub(r.lastSweep) < rateLimitSweepInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastSweep = now
+	r.mu.Unlock()
+	deleted := 0
+	for {
+		resp, err := r.db.Call17("rate_limit_sweep", []interface{}{now.UnixMilli(), rateLimitSweepBatch})
+		if err != nil {
+			r.l.Warn("failed to delete refilled rate limit buckets", zap.Error(err))
+			return
+		}
+		var batch int64
+		if len(resp.Data) > 0 {
+			batch, _ = toInt64(resp.Data[0])
+		}
+		deleted += int(batch)
+		if batch < rateLimitSweepBatch {
+			break
+		}
+	}
+	r.l.Debug("deleted refilled rate limit buckets", zap.Int("deleted", deleted))
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ReminderRepository struct {
+	db conn
+	l  *zap.Logger
+}
+
+func NewReminderRepository(db tarantool.Connector, l *zap.Logger) *ReminderRepository {
+	return &ReminderRepository{
+		db: conn{db},
+		l:  l,
+	}
+}
+
+// Remindable returns the users who have not opted out and were last reminded before remindedBefore,
+// the users are checked by remindable_users function from init.lua in one call
+func (r *ReminderRepository) Remindable(userIDs []string, remindedBefore time.Time) ([]string, error) {
+	resp, err := r.db.Call17("remindable_users", []interface{}{userIDs, uint64(max(remindedBefore.Unix(), 0))})
+	if err != nil {
+		r.l.Debug("failed to select remindable users", zap.Error(err))
+		return nil, fmt.Errorf("repository: database call error: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, nil
+	}
+	users, ok := resp.Data[0].([]interface{})
+	if !ok {
+		r.l.Debug("unexpected data type", zap.Any("data", resp.Data))
+		return nil, models.ErrFailedToProcessData
+	}
+	remindable := make([]string, 0, len(users))
+	for _, user := range users {
+		userID, ok := user.(string)
+		if !ok {
+			return nil, models.ErrFailedToProcessData
+		}
+		remindable = append(remindable, userID)
+	}
+	return remindable, nil
+}
+
+// SetOptOut turns reminders off or back on for the user
+func (r *ReminderRepository) SetOptOut(userID string, optOut bool) error {
+	var err error
+	if optOut {
+		_, err = r.db.Replace("reminder_optouts", []interface{}{userID})
+	} else {
+		_, err = r.db.Delete("reminder_optouts", "primary", []interface{}{userID})
+	}
+	if err != nil {
+		r.l.Debug("failed to update opt-out", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	r.l.Debug("updated reminder opt-out",
+		zap.String("user_id", userID),
+		zap.Bool("opt_out", optOut))
+	return nil
+}
+
+func (r *ReminderRepository) SetReminded(userID string, sentAt time.Time) error {
+	_, err := r.db.Replace("reminders", []interface{}{userID, uint64(sentAt.Unix())})
+	if err != nil {
+		r.l.Debug("failed to replace reminder", zap.Error(err))
+		return fmt.Errorf("repository: database replace error: %w", err)
+	}
+	return nil
+}
+
+// toInt64 converts msgpack integer of any width
+func toInt64(i interface{}) (int64, bool) {
+	switch x := i.(type) {
+	case int64:
+		return x, true
+	case uint64:
+		return int64(x), true
+	case int:
+		return int64(x), true
+	case uint:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case uint8:
+		return int64(x), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SQLiteAuditRepository struct {
+	db *sql.DB
+	l  *zap.Logger
+}
+
+func NewSQLiteAuditRepository(db *sql.DB, l *zap.Logger) *SQLiteAuditRepository {
+	return &SQLiteAuditRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+// Append inserts the entry, created_at is stored in milliseconds so that close actions keep their order
+func (r *SQLiteAuditRepository) Append(entry *models.AuditEntry) error {
+	_, err := r.db.Exec(`INSERT INTO audit
+		(id, poll_id, action, actor_id, channel_id, outcome, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.PollID, string(entry.Action), entry.ActorID, entry.ChannelID, entry.Outcome,
+		entry.CreatedAt.UnixMilli())
+	if err != nil {
+		r.l.Debug("failed to insert audit entry", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// ListByPoll returns entries of the poll from the oldest to the newest
+func (r *SQLiteAuditRepository) ListByPoll(pollID string) ([]*models.AuditEntry, error) {
+	rows, err := r.db.Query(`SELECT id, poll_id, action, actor_id, channel_id, outcome, created_at
+		FROM audit WHERE poll_id = ? ORDER BY created_at, id`, pollID)
+	if err != nil {
+		r.l.Debug("failed to select audit entries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		entry := &models.AuditEntry{}
+		var action string
+		var createdAt int64
+		err = rows.Scan(&entry.ID, &entry.PollID, &action, &entry.ActorID, &entry.ChannelID, &entry.Outcome,
+			&createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("repository: database select error: %w", err)
+		}
+		entry.Action = models.AuditAction(action)
+		entry.CreatedAt = time.UnixMilli(createdAt)
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select audit entries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return entries, nil
+}
+
+// DeleteActions deletes entries of the poll with the given actions, returns the number of deleted entries
+func (r *SQLiteAuditRepository) DeleteActions(pollID string, actions ...models.AuditAction) (int, error) {
+	deleted := 0
+	for _, action := range actions {
+		result, err := r.db.Exec(`DELETE FROM audit WHERE poll_id = ? AND action = ?`, pollID, string(action))
+		if err != nil {
+			r.l.Debug("failed to delete audit entries", zap.Error(err))
+			return deleted, fmt.Errorf("repository: database delete error: %w", err)
+		}
+		affected, _ := result.RowsAffected()
+		deleted += int(affected)
+	}
+	return deleted, nil
+}
+
+// DeleteBefore deletes up to limit entries created before t, returns the number of deleted entries
+func (r *SQLiteAuditRepository) DeleteBefore(t time.Time, limit uint32) (int, error) {
+	result, err := r.db.Exec(`DELETE FROM audit WHERE id IN
+		(SELECT id FROM audit WHERE created_at < ? ORDER BY created_at LIMIT ?)`, t.UnixMilli(), int64(limit))
+	if err != nil {
+		r.l.Debug("failed to delete expired audit entries", zap.Error(err))
+		return 0, fmt.Errorf("repository: database delete error: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return int(deleted), nil
+}
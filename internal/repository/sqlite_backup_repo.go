@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"go.uber.org/zap"
+)
+
+// SQLiteBackupRepository reads and writes SQLite tables as tuples of BackupSpaces,
+// the tables keep times in the same units as the spaces
+type SQLiteBackupRepository struct {
+	db *sql.DB
+	l  *zap.Logger
+}
+
+func NewSQLiteBackupRepository(db *sql.DB, l *zap.Logger) *SQLiteBackupRepository {
+	return &SQLiteBackupRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+// Scan calls fn for every row of the table of the space
+func (r *SQLiteBackupRepository) Scan(space string, fn func(tuple []interface{}) error) error {
+	var query string
+	var scan func(rows *sql.Rows) ([]interface{}, error)
+	switch space {
+	case "polls":
+		query = "SELECT " + pollColumns + " FROM polls ORDER BY id"
+		scan = func(rows *sql.Rows) ([]interface{}, error) {
+			poll, err := scanSQLitePoll(rows)
+			if err != nil {
+				return nil, err
+			}
+			return pollToTuple(poll)
+		}
+	case "votes":
+		query = "SELECT poll_id, user_id, choice_id FROM votes ORDER BY poll_id, user_id, choice_id"
+		scan = func(rows *sql.Rows) ([]interface{}, error) {
+			var pollID, userID, choiceID string
+			err := rows.Scan(&pollID, &userID, &choiceID)
+			return []interface{}{pollID, userID, choiceID}, err
+		}
+	case "reminder_optouts":
+		query = "SELECT user_id FROM reminder_optouts ORDER BY user_id"
+		scan = func(rows *sql.Rows) ([]interface{}, error) {
+			var userID string
+			err := rows.Scan(&userID)
+			return []interface{}{userID}, err
+		}
+	case "reminders":
+		query = "SELECT user_id, sent_at FROM reminders ORDER BY user_id"
+		scan = func(rows *sql.Rows) ([]interface{}, error) {
+			var userID string
+			var sentAt int64
+			err := rows.Scan(&userID, &sentAt)
+			return []interface{}{userID, uint64(sentAt)}, err
+		}
+	case "audit":
+		query = `SELECT id, poll_id, action, actor_id, channel_id, outcome, created_at
+			FROM audit ORDER BY created_at, id`
+		scan = func(rows *sql.Rows) ([]interface{}, error) {
+			var id, pollID, action, actorID, channelID, outcome string
+			var createdAt int64
+			err := rows.Scan(&id, &pollID, &action, &actorID, &channelID, &outcome, &createdAt)
+			return []interface{}{id, pollID, action, actorID, channelID, outcome, uint64(createdAt)}, err
+		}
+	default:
+		return fmt.Errorf("repository: unknown backup space %s", space)
+	}
+	rows, err := r.db.Query(query)
+	if err != nil {
+		r.l.Debug("failed to select rows", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tuple, err := scan(rows)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		if err = fn(tuple); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select rows", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database select error: %w", err)
+	}
+	return nil
+}
+
+// Put upserts the row of the tuple, so restoring the same tuple again does not change the table.
+// Audit entries can not be updated, so existing ones are skipped
+func (r *SQLiteBackupRepository) Put(space string, tuple []interface{}) error {
+	var err error
+	switch space {
+	case "polls":
+		err = r.putPoll(tuple)
+	case "votes":
+		_, err = r.db.Exec(`INSERT INTO votes (poll_id, user_id, choice_id) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING`, tupleStrings(tuple, 3)...)
+	case "reminder_optouts":
+		_, err = r.db.Exec("INSERT INTO reminder_optouts (user_id) VALUES (?) ON CONFLICT DO NOTHING",
+			tupleStrings(tuple, 1)...)
+	case "reminders":
+		_, err = r.db.Exec(`INSERT INTO reminders (user_id, sent_at) VALUES (?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET sent_at = excluded.sent_at`,
+			tupleStrings(tuple, 1)[0], int64(tupleUnsigned(tuple, 1)))
+	case "audit":
+		args := append(tupleStrings(tuple, 6), int64(tupleUnsigned(tuple, 6)))
+		_, err = r.db.Exec(`INSERT INTO audit (id, poll_id, action, actor_id, channel_id, outcome, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`, args...)
+	default:
+		return fmt.Errorf("repository: unknown backup space %s", space)
+	}
+	if err != nil {
+		r.l.Debug("failed to upsert row", zap.String("space", space), zap.Error(err))
+		return fmt.Errorf("repository: database upsert error: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteBackupRepository) putPoll(tuple []interface{}) error {
+	poll, err := pollFromBackupTuple(tuple)
+	if err != nil {
+		return err
+	}
+	options, votes, settings, err := marshalPoll(poll)
+	if err != nil {
+		return err
+	}
+	var deletedAt, endedAt sql.NullInt64
+	if poll.DeletedAt != nil {
+		deletedAt = sql.NullInt64{Int64: poll.DeletedAt.Unix(), Valid: true}
+	}
+	if poll.EndedAt != nil {
+		endedAt = sql.NullInt64{Int64: poll.EndedAt.Unix(), Valid: true}
+	}
+	_, err = r.db.Exec(`INSERT INTO polls (`+pollColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET question = excluded.question, options = excluded.options,
+			votes = excluded.votes, creator_id = excluded.creator_id, is_active = excluded.is_active,
+			channel_id = excluded.channel_id, settings = excluded.settings, post_id = excluded.post_id,
+			reminded = excluded.reminded, root_id = excluded.root_id, deleted_at = excluded.deleted_at,
+			ended_at = excluded.ended_at, pinned = excluded.pinned, anonymized = excluded.anonymized`,
+		poll.ID, poll.Question, string(options), string(votes), poll.CreatorID, poll.IsActive, poll.ChannelID,
+		string(settings), poll.PostID, poll.Reminded, poll.RootID, deletedAt, endedAt, poll.Pinned, poll.Anonymized)
+	return err
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"go.uber.org/zap"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// MigrateSQLite applies migrations which are not applied yet in the order of their file names,
+// each migration runs in its own transaction which holds the write lock of the database file
+func MigrateSQLite(ctx context.Context, db *sql.DB, l *zap.Logger) error {
+	names, err := fs.Glob(sqliteMigrations, "migrations/sqlite/*.sql")
+	if err != nil {
+		return fmt.Errorf("repository: failed to list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("repository: failed to create schema_migrations: %w", err)
+	}
+	for _, name := range names {
+		version := strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], ".sql")
+		migration, err := sqliteMigrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("repository: failed to read migration %s: %w", version, err)
+		}
+		applied, err := applySQLiteMigration(ctx, db, version, string(migration))
+		if err != nil {
+			return fmt.Errorf("repository: failed to apply migration %s: %w", version, err)
+		}
+		if applied {
+			l.Info("applied migration", zap.String("version", version))
+		}
+	}
+	return nil
+}
+
+func applySQLiteMigration(ctx context.Context, db *sql.DB, version, migration string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var applied bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)", version).
+		Scan(&applied)
+	if err != nil || applied {
+		return false, err
+	}
+	if _, err = tx.ExecContext(ctx, migration); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, unixepoch())", version)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
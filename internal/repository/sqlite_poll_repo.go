@@ -0,0 +1,466 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// SQLitePollRepository keeps polls and votes in an SQLite file, transactions begin with the write lock
+// so votes and their counts are changed without races between bot goroutines
+type SQLitePollRepository struct {
+	db *sql.DB
+	l  *zap.Logger
+}
+
+func NewSQLitePollRepository(db *sql.DB, l *zap.Logger) *SQLitePollRepository {
+	return &SQLitePollRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+func (r *SQLitePollRepository) CreatePoll(poll *models.Poll) (string, []models.Option, error) {
+	r.l.Debug("creating poll", zap.Any("poll", poll))
+	options, votes, settings, err := marshalPoll(poll)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = r.db.Exec(`INSERT INTO polls
+		(id, question, options, votes, creator_id, is_active, channel_id, settings, post_id, reminded, root_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		poll.ID, poll.Question, string(options), string(votes), poll.CreatorID, poll.IsActive, poll.ChannelID,
+		string(settings), poll.PostID, poll.Reminded, poll.RootID)
+	if err != nil {
+		r.l.Debug("error inserting poll", zap.Error(err))
+		return "", nil, fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return poll.ID, poll.Options, nil
+}
+
+func (r *SQLitePollRepository) Vote(pollID, choiceID, userID string) error {
+	return r.changeVote(pollID, choiceID, func(tx *sql.Tx, poll *models.Poll) error {
+		rows, err := tx.Query("SELECT choice_id FROM votes WHERE poll_id = ? AND user_id = ?", pollID, userID)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		choices, err := collectStrings(rows)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		for _, choice := range choices {
+			if choice == choiceID {
+				r.l.Debug("vote already exist",
+					zap.String("poll_id", pollID),
+					zap.String("user_id", userID))
+				return models.ErrVoteAlreadyExists
+			}
+		}
+		if maxChoices := poll.Settings.Choices(); len(choices) >= maxChoices {
+			r.l.Debug("user has no choices left",
+				zap.String("poll_id", pollID),
+				zap.String("user_id", userID))
+			if maxChoices == 1 {
+				return models.ErrVoteAlreadyExists
+			}
+			return models.ErrTooManyChoices
+		}
+		_, err = tx.Exec("INSERT INTO votes (poll_id, user_id, choice_id) VALUES (?, ?, ?)", pollID, userID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database insert error: %w", err)
+		}
+		poll.Votes[choiceID]++
+		return setVoteCounts(tx, pollID, poll.Votes)
+	})
+}
+
+// Unvote removes the user vote for the choice from an active poll
+func (r *SQLitePollRepository) Unvote(pollID, choiceID, userID string) error {
+	return r.changeVote(pollID, choiceID, func(tx *sql.Tx, poll *models.Poll) error {
+		result, err := tx.Exec("DELETE FROM votes WHERE poll_id = ? AND user_id = ? AND choice_id = ?",
+			pollID, userID, choiceID)
+		if err != nil {
+			return fmt.Errorf("repository: database delete error: %w", err)
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			return models.ErrVoteNotFound
+		}
+		if poll.Votes[choiceID] > 0 {
+			poll.Votes[choiceID]--
+		}
+		return setVoteCounts(tx, pollID, poll.Votes)
+	})
+}
+
+// changeVote reads the poll in a write transaction, checks that the poll accepts votes for the choice
+// and runs change in the same transaction
+func (r *SQLitePollRepository) changeVote(pollID, choiceID string, change func(tx *sql.Tx, poll *models.Poll) error) error {
+	err := r.inTx(func(tx *sql.Tx) error {
+		poll, err := scanSQLitePoll(tx.QueryRow(
+			"SELECT "+pollColumns+" FROM polls WHERE id = ? AND deleted_at IS NULL", pollID))
+		if err != nil {
+			return err
+		}
+		if !poll.IsActive || poll.Settings.Deadline != nil && !time.Now().Before(*poll.Settings.Deadline) {
+			r.l.Debug("poll is not active", zap.String("poll_id", pollID))
+			return models.ErrPollIsEnd
+		}
+		if _, ok := poll.Votes[choiceID]; !ok {
+			r.l.Debug("option not found", zap.String("choice_id", choiceID))
+			return models.ErrOptionIsNotFound
+		}
+		return change(tx, poll)
+	})
+	if err != nil {
+		r.l.Debug("failed to change vote", zap.String("poll_id", pollID), zap.Error(err))
+	}
+	return err
+}
+
+func (r *SQLitePollRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("repository: database begin error: %w", err)
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("repository: database commit error: %w", err)
+	}
+	return nil
+}
+
+// GetPollResult returns the poll, deleted polls are not found
+func (r *SQLitePollRepository) GetPollResult(pollID string) (*models.Poll, error) {
+	return r.getPoll("id = ? AND deleted_at IS NULL", pollID)
+}
+
+// GetPollByPost returns the poll announced in the post
+func (r *SQLitePollRepository) GetPollByPost(postID string) (*models.Poll, error) {
+	return r.getPoll("post_id = ? AND deleted_at IS NULL", postID)
+}
+
+func (r *SQLitePollRepository) getPoll(where string, arg string) (*models.Poll, error) {
+	poll, err := scanSQLitePoll(r.db.QueryRow("SELECT "+pollColumns+" FROM polls WHERE "+where+" LIMIT 1", arg))
+	if err != nil {
+		r.l.Debug("failed to select poll", zap.String("key", arg), zap.Error(err))
+		return nil, err
+	}
+	return poll, nil
+}
+
+// ListPolls returns polls which are not deleted
+func (r *SQLitePollRepository) ListPolls() ([]*models.Poll, error) {
+	return r.listPolls("SELECT " + pollColumns + " FROM polls WHERE deleted_at IS NULL ORDER BY id")
+}
+
+// ListAllPolls returns all polls including deleted ones
+func (r *SQLitePollRepository) ListAllPolls() ([]*models.Poll, error) {
+	return r.listPolls("SELECT " + pollColumns + " FROM polls ORDER BY id")
+}
+
+func (r *SQLitePollRepository) listPolls(query string) ([]*models.Poll, error) {
+	rows, err := r.db.Query(query)
+	if err != nil {
+		r.l.Debug("failed to select polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var polls []*models.Poll
+	for rows.Next() {
+		poll, err := scanSQLitePoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, poll)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return polls, nil
+}
+
+func (r *SQLitePollRepository) SetPostID(pollID, postID, rootID string) error {
+	return r.exec("UPDATE polls SET post_id = ?, root_id = ? WHERE id = ?", postID, rootID, pollID)
+}
+
+func (r *SQLitePollRepository) SetReminded(pollID string) error {
+	return r.exec("UPDATE polls SET reminded = 1 WHERE id = ?", pollID)
+}
+
+func (r *SQLitePollRepository) EndPoll(pollID, userID string) error {
+	poll, err := r.GetPollResult(pollID)
+	if err != nil {
+		return err
+	}
+	if !poll.IsActive {
+		r.l.Debug("poll is not active", zap.String("poll_id", pollID))
+		return models.ErrPollAlreadyEnded
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	return r.Deactivate(pollID)
+}
+
+// Deactivate ends the active poll without checking its owner, an ended poll is not changed
+// and ErrPollAlreadyEnded is returned, so only one of concurrent calls ends it
+func (r *SQLitePollRepository) Deactivate(pollID string) error {
+	err := r.update("UPDATE polls SET is_active = 0, ended_at = ? WHERE id = ? AND is_active = 1",
+		time.Now().Unix(), pollID)
+	if errors.Is(err, models.ErrPollNotFound) {
+		if _, err = r.getPoll("id = ?", pollID); err != nil {
+			return err
+		}
+		return models.ErrPollAlreadyEnded
+	}
+	return err
+}
+
+// DeletePoll moves the poll to trash, it is hidden from all reads until restored or purged
+func (r *SQLitePollRepository) DeletePoll(pollID, userID string, now time.Time) error {
+	poll, err := r.GetPollResult(pollID)
+	if err != nil {
+		return err
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	return r.update("UPDATE polls SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now.Unix(), pollID)
+}
+
+// UndeletePoll restores the poll deleted after notBefore
+func (r *SQLitePollRepository) UndeletePoll(pollID, userID string, notBefore time.Time) error {
+	poll, err := r.getPoll("id = ?", pollID)
+	if err != nil {
+		return err
+	}
+	if poll.DeletedAt == nil {
+		return models.ErrPollNotDeleted
+	}
+	if poll.CreatorID != userID {
+		r.l.Debug("user is not the owner of the poll", zap.String("user_id", userID))
+		return models.ErrUserNotOwner
+	}
+	if poll.DeletedAt.Before(notBefore) {
+		return models.ErrUndeleteExpired
+	}
+	return r.exec("UPDATE polls SET deleted_at = NULL WHERE id = ?", pollID)
+}
+
+// DeletedBefore returns ids of up to limit polls deleted before t
+func (r *SQLitePollRepository) DeletedBefore(t time.Time, limit uint32) ([]string, error) {
+	rows, err := r.db.Query("SELECT id FROM polls WHERE deleted_at < ? ORDER BY deleted_at LIMIT ?",
+		t.Unix(), int64(limit))
+	if err != nil {
+		r.l.Debug("failed to select deleted polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	ids, err := collectStrings(rows)
+	if err != nil {
+		r.l.Debug("failed to select deleted polls", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return ids, nil
+}
+
+// PurgePoll removes the poll, its votes are removed by the foreign key in the same transaction
+func (r *SQLitePollRepository) PurgePoll(pollID string) error {
+	return r.exec("DELETE FROM polls WHERE id = ?", pollID)
+}
+
+// SetEndedAt sets the time the poll was ended, it is used for polls ended before the time was stored
+func (r *SQLitePollRepository) SetEndedAt(pollID string, endedAt time.Time) error {
+	return r.update("UPDATE polls SET ended_at = ? WHERE id = ? AND deleted_at IS NULL", endedAt.Unix(), pollID)
+}
+
+// SetPinned pins the poll so that the retention policy keeps it or releases the pin
+func (r *SQLitePollRepository) SetPinned(pollID string, pinned bool) error {
+	return r.update("UPDATE polls SET pinned = ? WHERE id = ? AND deleted_at IS NULL", pinned, pollID)
+}
+
+// AnonymizeVotes replaces voter ids of the poll with anon:N and marks it as anonymized in one transaction,
+// one voter gets the same id for all choices so the number of voters is kept
+func (r *SQLitePollRepository) AnonymizeVotes(pollID string) error {
+	err := r.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT DISTINCT user_id FROM votes WHERE poll_id = ? ORDER BY user_id", pollID)
+		if err != nil {
+			return err
+		}
+		voters, err := collectStrings(rows)
+		if err != nil {
+			return err
+		}
+		for i, voter := range voters {
+			_, err = tx.Exec("UPDATE votes SET user_id = ? WHERE poll_id = ? AND user_id = ?",
+				models.AnonymousVoter+strconv.Itoa(i+1), pollID, voter)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE polls SET anonymized = 1 WHERE id = ?", pollID)
+		return err
+	})
+	if err != nil {
+		r.l.Debug("failed to anonymize votes", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// GetVoters returns ids of users who voted in the poll
+func (r *SQLitePollRepository) GetVoters(pollID string) ([]string, error) {
+	votes, err := r.GetVotes(pollID)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueVoters(votes), nil
+}
+
+func (r *SQLitePollRepository) GetVotes(pollID string) ([]models.Vote, error) {
+	return r.selectVotes("SELECT poll_id, user_id, choice_id FROM votes WHERE poll_id = ? ORDER BY user_id, choice_id",
+		pollID)
+}
+
+// ListAllVotes returns rows of the votes table of all polls
+func (r *SQLitePollRepository) ListAllVotes() ([]models.Vote, error) {
+	return r.selectVotes("SELECT poll_id, user_id, choice_id FROM votes ORDER BY poll_id, user_id, choice_id")
+}
+
+// DeleteVote removes the vote row without changing the poll counts
+func (r *SQLitePollRepository) DeleteVote(vote models.Vote) error {
+	return r.exec("DELETE FROM votes WHERE poll_id = ? AND user_id = ? AND choice_id = ?",
+		vote.PollID, vote.UserID, vote.ChoiceID)
+}
+
+// RecountVotes sets the vote counts of the poll to the numbers of its votes,
+// transactions are immediate so votes cast meanwhile wait for the recount
+func (r *SQLitePollRepository) RecountVotes(pollID string) error {
+	err := r.inTx(func(tx *sql.Tx) error {
+		poll, err := scanSQLitePoll(tx.QueryRow("SELECT "+pollColumns+" FROM polls WHERE id = ?", pollID))
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query("SELECT choice_id FROM votes WHERE poll_id = ?", pollID)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		choices, err := collectStrings(rows)
+		if err != nil {
+			return fmt.Errorf("repository: database select error: %w", err)
+		}
+		return setVoteCounts(tx, pollID, countVotes(poll, choices))
+	})
+	if err != nil {
+		r.l.Debug("failed to recount votes", zap.String("poll_id", pollID), zap.Error(err))
+	}
+	return err
+}
+
+func (r *SQLitePollRepository) selectVotes(query string, args ...interface{}) ([]models.Vote, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		r.l.Debug("failed to select votes", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var votes []models.Vote
+	for rows.Next() {
+		var vote models.Vote
+		if err = rows.Scan(&vote.PollID, &vote.UserID, &vote.ChoiceID); err != nil {
+			return nil, fmt.Errorf("repository: database select error: %w", err)
+		}
+		votes = append(votes, vote)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select votes", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return votes, nil
+}
+
+func (r *SQLitePollRepository) exec(query string, args ...interface{}) error {
+	if _, err := r.db.Exec(query, args...); err != nil {
+		r.l.Debug("failed to update poll", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// update runs the statement changing one poll, the poll is not found if no row was changed
+func (r *SQLitePollRepository) update(query string, args ...interface{}) error {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		r.l.Debug("failed to update poll", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return models.ErrPollNotFound
+	}
+	return nil
+}
+
+func setVoteCounts(tx *sql.Tx, pollID string, votes map[string]int) error {
+	votesJSON, err := json.Marshal(votes)
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	if _, err = tx.Exec("UPDATE polls SET votes = ? WHERE id = ?", string(votesJSON), pollID); err != nil {
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+// scanSQLitePoll reads a row of pollColumns, no row means the poll is not found
+func scanSQLitePoll(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.Poll, error) {
+	poll := &models.Poll{}
+	var options, votes, settings string
+	var deletedAt, endedAt sql.NullInt64
+	err := row.Scan(&poll.ID, &poll.Question, &options, &votes, &poll.CreatorID, &poll.IsActive,
+		&poll.ChannelID, &settings, &poll.PostID, &poll.Reminded, &poll.RootID,
+		&deletedAt, &endedAt, &poll.Pinned, &poll.Anonymized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrPollNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	if deletedAt.Valid {
+		t := time.Unix(deletedAt.Int64, 0)
+		poll.DeletedAt = &t
+	}
+	if endedAt.Valid {
+		t := time.Unix(endedAt.Int64, 0)
+		poll.EndedAt = &t
+	}
+	if err = unmarshalPoll(poll, []byte(options), []byte(votes), []byte(settings)); err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+func collectStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SQLiteReminderRepository struct {
+	db *sql.DB
+	l  *zap.Logger
+}
+
+func NewSQLiteReminderRepository(db *sql.DB, l *zap.Logger) *SQLiteReminderRepository {
+	return &SQLiteReminderRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+// Remindable returns the users who have not opted out and were last reminded before remindedBefore,
+// the ids are passed as a JSON array so the users are checked in one query
+func (r *SQLiteReminderRepository) Remindable(userIDs []string, remindedBefore time.Time) ([]string, error) {
+	ids, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("repository: failed to marshal user ids: %w", err)
+	}
+	rows, err := r.db.Query(`SELECT u.value FROM json_each(?) AS u
+		WHERE NOT EXISTS (SELECT 1 FROM reminder_optouts o WHERE o.user_id = u.value)
+			AND NOT EXISTS (SELECT 1 FROM reminders r WHERE r.user_id = u.value AND r.sent_at >= ?)`,
+		string(ids), remindedBefore.Unix())
+	if err != nil {
+		r.l.Debug("failed to select remindable users", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var remindable []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("repository: database select error: %w", err)
+		}
+		remindable = append(remindable, userID)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select remindable users", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return remindable, nil
+}
+
+// SetOptOut turns reminders off or back on for the user
+func (r *SQLiteReminderRepository) SetOptOut(userID string, optOut bool) error {
+	query := "DELETE FROM reminder_optouts WHERE user_id = ?"
+	if optOut {
+		query = "INSERT INTO reminder_optouts (user_id) VALUES (?) ON CONFLICT DO NOTHING"
+	}
+	if _, err := r.db.Exec(query, userID); err != nil {
+		r.l.Debug("failed to update opt-out", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	r.l.Debug("updated reminder opt-out",
+		zap.String("user_id", userID),
+		zap.Bool("opt_out", optOut))
+	return nil
+}
+
+func (r *SQLiteReminderRepository) SetReminded(userID string, sentAt time.Time) error {
+	_, err := r.db.Exec(`INSERT INTO reminders (user_id, sent_at) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET sent_at = excluded.sent_at`, userID, sentAt.Unix())
+	if err != nil {
+		r.l.Debug("failed to replace reminder", zap.Error(err))
+		return fmt.Errorf("repository: database replace error: %w", err)
+	}
+	return nil
+}
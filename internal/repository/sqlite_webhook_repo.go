@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SQLiteWebhookRepository struct {
+	db *sql.DB
+	l  *zap.Logger
+}
+
+func NewSQLiteWebhookRepository(db *sql.DB, l *zap.Logger) *SQLiteWebhookRepository {
+	return &SQLiteWebhookRepository{
+		db: db,
+		l:  l,
+	}
+}
+
+func (r *SQLiteWebhookRepository) Enqueue(delivery *models.Delivery) error {
+	_, err := r.db.Exec(`INSERT INTO webhook_deliveries
+		(id, url, event, payload, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		delivery.ID, delivery.URL, string(delivery.Event), delivery.Payload, delivery.Attempts,
+		delivery.NextAttemptAt.Unix())
+	if err != nil {
+		r.l.Debug("failed to insert delivery", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// Due returns deliveries whose next attempt time has come
+func (r *SQLiteWebhookRepository) Due(now time.Time, limit uint32) ([]*models.Delivery, error) {
+	rows, err := r.db.Query(`SELECT id, url, event, payload, attempts, next_attempt_at
+		FROM webhook_deliveries WHERE next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?`, now.Unix(), int64(limit))
+	if err != nil {
+		r.l.Debug("failed to select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	defer rows.Close()
+	var deliveries []*models.Delivery
+	for rows.Next() {
+		delivery := &models.Delivery{}
+		var event string
+		var next int64
+		err = rows.Scan(&delivery.ID, &delivery.URL, &event, &delivery.Payload, &delivery.Attempts, &next)
+		if err != nil {
+			return nil, fmt.Errorf("repository: database select error: %w", err)
+		}
+		delivery.Event = models.EventType(event)
+		delivery.NextAttemptAt = time.Unix(next, 0)
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		r.l.Debug("failed to select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *SQLiteWebhookRepository) Reschedule(id string, attempts int, next time.Time) error {
+	_, err := r.db.Exec("UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ? WHERE id = ?",
+		attempts, next.Unix(), id)
+	if err != nil {
+		r.l.Debug("failed to update delivery", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteWebhookRepository) Delete(id string) error {
+	_, err := r.db.Exec("DELETE FROM webhook_deliveries WHERE id = ?", id)
+	if err != nil {
+		r.l.Debug("failed to delete delivery", zap.Error(err))
+		return fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return nil
+}
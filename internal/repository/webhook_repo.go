@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"github.com/tarantool/go-tarantool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type WebhookRepository struct {
+	db conn
+	l  *zap.Logger
+}
+
+func NewWebhookRepository(db tarantool.Connector, l *zap.Logger) *WebhookRepository {
+	return &WebhookRepository{
+		db: conn{db},
+		l:  l,
+	}
+}
+
+func (r *WebhookRepository) Enqueue(delivery *models.Delivery) error {
+	_, err := r.db.Insert("webhook_deliveries", []interface{}{
+		delivery.ID,
+		delivery.URL,
+		string(delivery.Event),
+		delivery.Payload,
+		uint64(delivery.Attempts),
+		uint64(delivery.NextAttemptAt.Unix()),
+	})
+	if err != nil {
+		r.l.Debug("failed to insert delivery", zap.Error(err))
+		return fmt.Errorf("repository: database insert error: %w", err)
+	}
+	return nil
+}
+
+// Due returns deliveries whose next attempt time has come
+func (r *WebhookRepository) Due(now time.Time, limit uint32) ([]*models.Delivery, error) {
+	resp, err := r.db.Select("webhook_deliveries", "next_attempt", 0, limit, tarantool.IterLe,
+		[]interface{}{uint64(now.Unix())})
+	if err != nil {
+		r.l.Debug("failed to select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("repository: database select error: %w", err)
+	}
+	deliveries := make([]*models.Delivery, 0, len(resp.Data))
+	for _, data := range resp.Data {
+		deliveryTuple, ok := data.([]interface{})
+		if !ok || len(deliveryTuple) < 6 {
+			r.l.Debug("unexpected data type", zap.Any("data", data))
+			return nil, models.ErrFailedToProcessData
+		}
+		delivery := &models.Delivery{}
+		delivery.ID, _ = deliveryTuple[0].(string)
+		delivery.URL, _ = deliveryTuple[1].(string)
+		event, _ := deliveryTuple[2].(string)
+		delivery.Event = models.EventType(event)
+		delivery.Payload, _ = deliveryTuple[3].(string)
+		attempts, _ := toInt64(deliveryTuple[4])
+		delivery.Attempts = int(attempts)
+		next, _ := toInt64(deliveryTuple[5])
+		delivery.NextAttemptAt = time.Unix(next, 0)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepository) Reschedule(id string, attempts int, next time.Time) error {
+	_, err := r.db.Update("webhook_deliveries", "primary", []interface{}{id}, []interface{}{
+		[]interface{}{"=", 4, uint64(attempts)},
+		[]interface{}{"=", 5, uint64(next.Unix())},
+	})
+	if err != nil {
+		r.l.Debug("failed to update delivery", zap.Error(err))
+		return fmt.Errorf("repository: database update error: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) Delete(id string) error {
+	_, err := r.db.Delete("webhook_deliveries", "primary", []interface{}{id})
+	if err != nil {
+		r.l.Debug("failed to delete delivery", zap.Error(err))
+		return fmt.Errorf("repository: database delete error: %w", err)
+	}
+	return nil
+}
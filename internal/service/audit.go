@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jaam8/mattermost_bot/internal/metrics"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// auditPurgeBatch is how many expired audit entries are deleted per purge
+const auditPurgeBatch = 1000
+
+// record appends the poll action to the audit log, the channel is taken from the poll when the caller has it.
+// Errors are only logged so that the audit never breaks poll commands
+func (s *PollService) record(action models.AuditAction, poll *models.Poll, pollID, actorID string, err error) {
+	if s.audit == nil {
+		return
+	}
+	entry := &models.AuditEntry{
+		ID:        uuid.New().String(),
+		PollID:    pollID,
+		Action:    action,
+		ActorID:   actorID,
+		Outcome:   models.AuditOK,
+		CreatedAt: time.Now().UTC(),
+	}
+	if poll != nil {
+		entry.ChannelID = poll.ChannelID
+		if poll.Settings.Anonymous && (action == models.AuditVote || action == models.AuditUnvote) {
+			entry.ActorID = ""
+		}
+	}
+	if err != nil {
+		entry.Outcome = metrics.ErrorLabel(err)
+	}
+	if err = s.audit.Append(entry); err != nil {
+		s.l.Error("failed to append audit entry",
+			zap.String("poll_id", pollID),
+			zap.String("action", string(action)),
+			zap.Error(err))
+	}
+}
+
+// Audit returns audit entries of the poll for its owner or an admin, the owner of a deleted poll
+// is taken from the create entry
+func (s *PollService) Audit(pollID, userID string, isAdmin bool) ([]*models.AuditEntry, error) {
+	entries, err := s.audit.ListByPoll(pollID)
+	if err != nil {
+		s.l.Error("failed to list audit entries", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to list audit entries: %w", err)
+	}
+	ownerID := ""
+	poll, err := s.r.GetPollResult(pollID)
+	switch {
+	case err == nil:
+		ownerID = poll.CreatorID
+	case errors.Is(err, models.ErrPollNotFound):
+		for _, entry := range entries {
+			if entry.Action == models.AuditCreate && entry.Outcome == models.AuditOK {
+				ownerID = entry.ActorID
+			}
+		}
+		if len(entries) == 0 {
+			return nil, models.ErrPollNotFound
+		}
+	default:
+		s.l.Error("failed to get poll", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to get poll: %w", err)
+	}
+	if ownerID != userID && !isAdmin {
+		return nil, models.ErrUserNotOwner
+	}
+	return entries, nil
+}
+
+// PurgeAudit deletes audit entries older than the retention, zero retention keeps them forever
+func (s *PollService) PurgeAudit(now time.Time) (int, error) {
+	if s.audit == nil || s.cfg.AuditRetention <= 0 {
+		return 0, nil
+	}
+	deleted, err := s.audit.DeleteBefore(now.Add(-s.cfg.AuditRetention), auditPurgeBatch)
+	if err != nil {
+		s.l.Error("failed to purge audit entries", zap.Error(err))
+		return deleted, fmt.Errorf("service: failed to purge audit entries: %w", err)
+	}
+	return deleted, nil
+}
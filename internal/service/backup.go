@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/repository"
+	"go.uber.org/zap"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	backupFormat = "mattermost_bot/backup"
+	// BackupVersion is the version of the backup format, restore accepts backups up to it
+	BackupVersion = 1
+	// maxBackupLine limits one tuple of the backup, options of a poll are the largest field
+	maxBackupLine = 16 << 20
+)
+
+// backupHeader is the first line of the backup
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Spaces    []string  `json:"spaces"`
+}
+
+// backupRecord is one tuple of the backup
+type backupRecord struct {
+	Space string        `json:"space"`
+	Tuple []interface{} `json:"tuple"`
+}
+
+// BackupStore reads and writes the storage as tuples of repository.BackupSpaces,
+// every backend uses the same tuples, so a backup of one backend can be restored to another
+type BackupStore interface {
+	Scan(space string, fn func(tuple []interface{}) error) error
+	Put(space string, tuple []interface{}) error
+}
+
+// Backups writes poll data as JSON lines and restores it
+type Backups struct {
+	r BackupStore
+	l *zap.Logger
+}
+
+func NewBackups(r BackupStore, l *zap.Logger) *Backups {
+	return &Backups{r: r, l: l}
+}
+
+// Backup streams the header and all tuples of the backed up spaces to w, returns number of tuples per space
+func (b *Backups) Backup(w io.Writer) (map[string]int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	header := backupHeader{Format: backupFormat, Version: BackupVersion, CreatedAt: time.Now().UTC()}
+	for _, space := range repository.BackupSpaces {
+		header.Spaces = append(header.Spaces, space.Name)
+	}
+	if err := enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("service: failed to write backup header: %w", err)
+	}
+	counts := make(map[string]int, len(repository.BackupSpaces))
+	for _, space := range repository.BackupSpaces {
+		err := b.r.Scan(space.Name, func(tuple []interface{}) error {
+			counts[space.Name]++
+			return enc.Encode(backupRecord{Space: space.Name, Tuple: jsonTuple(tuple)})
+		})
+		if err != nil {
+			b.l.Error("failed to back up space", zap.String("space", space.Name), zap.Error(err))
+			return counts, fmt.Errorf("service: failed to back up space %s: %w", space.Name, err)
+		}
+		b.l.Info("backed up space",
+			zap.String("space", space.Name),
+			zap.Int("tuples", counts[space.Name]))
+	}
+	if err := bw.Flush(); err != nil {
+		return counts, fmt.Errorf("service: failed to write backup: %w", err)
+	}
+	return counts, nil
+}
+
+// Restore validates every tuple of the backup and replaces it in its space, audit entries already
+// present are kept, so a backup can be restored again without duplicates. With dryRun tuples are only validated. Returns number of tuples per space
+func (b *Backups) Restore(r io.Reader, dryRun bool) (map[string]int, error) {
+	spaces := make(map[string]repository.Space, len(repository.BackupSpaces))
+	for _, space := range repository.BackupSpaces {
+		spaces[space.Name] = space
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBackupLine)
+	counts := map[string]int{}
+	line := 0
+	headerRead := false
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if !headerRead {
+			if err := checkHeader(scanner.Bytes()); err != nil {
+				return counts, fmt.Errorf("service: line %d: %w", line, err)
+			}
+			headerRead = true
+			continue
+		}
+		var record backupRecord
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&record); err != nil {
+			return counts, fmt.Errorf("service: line %d: invalid record: %w", line, err)
+		}
+		space, ok := spaces[record.Space]
+		if !ok {
+			return counts, fmt.Errorf("service: line %d: unknown space %q", line, record.Space)
+		}
+		tuple, err := validateTuple(space, record.Tuple)
+		if err != nil {
+			return counts, fmt.Errorf("service: line %d: space %s: %w", line, space.Name, err)
+		}
+		if !dryRun {
+			if err = b.r.Put(space.Name, tuple); err != nil {
+				b.l.Error("failed to restore tuple", zap.Int("line", line), zap.Error(err))
+				return counts, fmt.Errorf("service: line %d: failed to restore tuple: %w", line, err)
+			}
+		}
+		counts[space.Name]++
+	}
+	if err := scanner.Err(); err != nil {
+		return counts, fmt.Errorf("service: failed to read backup: %w", err)
+	}
+	if !headerRead {
+		return counts, errors.New("service: backup is empty")
+	}
+	return counts, nil
+}
+
+func checkHeader(data []byte) error {
+	var header backupHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("invalid backup header: %w", err)
+	}
+	if header.Format != backupFormat {
+		return fmt.Errorf("not a backup of the bot, format %q", header.Format)
+	}
+	if header.Version < 1 || header.Version > BackupVersion {
+		return fmt.Errorf("unsupported backup version %d, supported up to %d", header.Version, BackupVersion)
+	}
+	return nil
+}
+
+// validateTuple checks the tuple against the space format and converts JSON numbers to tuple types
+func validateTuple(space repository.Space, raw []interface{}) ([]interface{}, error) {
+	if len(raw) > len(space.Fields) {
+		return nil, fmt.Errorf("tuple has %d fields, the space has %d", len(raw), len(space.Fields))
+	}
+	tuple := make([]interface{}, len(raw))
+	for i, field := range space.Fields {
+		if i >= len(raw) || raw[i] == nil {
+			if !field.Nullable {
+				return nil, fmt.Errorf("field %s is required", field.Name)
+			}
+			continue
+		}
+		value, ok := raw[i], false
+		switch field.Type {
+		case repository.FieldString:
+			_, ok = value.(string)
+		case repository.FieldBoolean:
+			_, ok = value.(bool)
+		case repository.FieldUnsigned:
+			if number, isNumber := value.(json.Number); isNumber {
+				var err error
+				value, err = strconv.ParseUint(number.String(), 10, 64)
+				ok = err == nil
+			}
+		case repository.FieldArray:
+			if _, ok = value.([]interface{}); ok {
+				value = tupleValue(value)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("field %s should be %s, got %v", field.Name, field.Type, raw[i])
+		}
+		tuple[i] = value
+	}
+	return tuple, nil
+}
+
+// jsonTuple converts msgpack maps with interface keys, which JSON can not encode
+func jsonTuple(tuple []interface{}) []interface{} {
+	converted := make([]interface{}, len(tuple))
+	for i, value := range tuple {
+		converted[i] = jsonValue(value)
+	}
+	return converted
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		return jsonTuple(v)
+	default:
+		return value
+	}
+}
+
+// tupleValue converts JSON numbers inside arrays and maps to integers or floats
+func tupleValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = tupleValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = tupleValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"strconv"
+	"time"
+)
+
+func validateSettings(settings models.Settings, options int) error {
+	if settings.Quorum < 0 || settings.QuorumPercent < 0 || settings.QuorumPercent > 100 {
+		return models.ErrInvalidQuorum
+	}
+	if t := settings.Threshold; t != nil {
+		if t.Num <= 0 || t.Den <= 0 || t.Num > t.Den {
+			return models.ErrInvalidThreshold
+		}
+	}
+	if settings.Deadline != nil && !settings.Deadline.After(time.Now()) {
+		return models.ErrInvalidDeadline
+	}
+	if settings.RemindBefore < 0 || settings.RemindBefore > 0 &&
+		(settings.Deadline == nil || time.Until(*settings.Deadline) <= settings.RemindBefore) {
+		return models.ErrInvalidRemind
+	}
+	if settings.MaxChoices < 0 || settings.MaxChoices > options {
+		return models.ErrInvalidMaxChoices
+	}
+	if settings.Reactions && options > 10 {
+		return models.ErrInvalidReactions
+	}
+	if settings.Reactions && settings.Anonymous {
+		return models.ErrAnonymousReactions
+	}
+	return nil
+}
+
+// evaluate applies quorum and threshold rules to the poll votes, turnout is the number of voters.
+// Without threshold the leading option passes if it has more votes than any other option,
+// in multiple choice polls threshold is the share of voters who chose the option
+func evaluate(poll *models.Poll, voters, members int) *models.Decision {
+	decision := &models.Decision{Members: members, Turnout: voters}
+	var winner *models.Option
+	top, tie := 0, false
+	for i, option := range poll.Options {
+		count := poll.Votes[strconv.Itoa(option.ID)]
+		switch {
+		case count > top:
+			top, tie = count, false
+			winner = &poll.Options[i]
+		case count == top:
+			tie = true
+		}
+	}
+	settings := poll.Settings
+	if decision.Turnout < settings.Quorum ||
+		decision.Turnout*100 < settings.QuorumPercent*members {
+		decision.Outcome = models.OutcomeNoQuorum
+		return decision
+	}
+	if top == 0 || tie {
+		decision.Outcome = models.OutcomeFailed
+		return decision
+	}
+	decision.Winner = winner
+	if t := settings.Threshold; t != nil && top*t.Den < t.Num*decision.Turnout {
+		decision.Outcome = models.OutcomeFailed
+		return decision
+	}
+	decision.Outcome = models.OutcomePassed
+	return decision
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+)
+
+const (
+	IntegrityOff    = "off"
+	IntegrityReport = "report"
+	IntegrityRepair = "repair"
+)
+
+type IntegrityConfig struct {
+	// Mode: integrity check at startup, off, report or repair
+	Mode string `yaml:"INTEGRITY_CHECK" env:"INTEGRITY_CHECK" env-default:"report"`
+}
+
+// Integrity finds votes without polls and vote counts which drifted from the votes space
+type Integrity struct {
+	r PollStore
+	l *zap.Logger
+}
+
+func NewIntegrity(r PollStore, l *zap.Logger) *Integrity {
+	return &Integrity{r: r, l: l}
+}
+
+// Check compares polls with the votes space, with repair orphan votes are deleted and
+// counts are recomputed from the votes space. Each poll is recomputed in one transaction,
+// so the repair is safe while the bot is running and votes cast meanwhile are counted
+func (i *Integrity) Check(repair bool) (*models.IntegrityReport, error) {
+	polls, err := i.r.ListAllPolls()
+	if err != nil {
+		i.l.Error("failed to list polls", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to list polls: %w", err)
+	}
+	votes, err := i.r.ListAllVotes()
+	if err != nil {
+		i.l.Error("failed to list votes", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to list votes: %w", err)
+	}
+	report := &models.IntegrityReport{Polls: len(polls), Votes: len(votes)}
+
+	byID := make(map[string]*models.Poll, len(polls))
+	for _, poll := range polls {
+		byID[poll.ID] = poll
+	}
+	actual := make(map[string]map[string]int, len(polls))
+	for _, vote := range votes {
+		poll, ok := byID[vote.PollID]
+		if !ok || !hasOption(poll, vote.ChoiceID) {
+			report.Orphans = append(report.Orphans, vote)
+			continue
+		}
+		if actual[vote.PollID] == nil {
+			actual[vote.PollID] = make(map[string]int)
+		}
+		actual[vote.PollID][vote.ChoiceID]++
+	}
+	mismatched := map[string]bool{}
+	for _, poll := range polls {
+		for _, option := range poll.Options {
+			choiceID := strconv.Itoa(option.ID)
+			stored, counted := poll.Votes[choiceID], actual[poll.ID][choiceID]
+			if stored != counted {
+				report.Mismatches = append(report.Mismatches, models.CountMismatch{
+					PollID: poll.ID, ChoiceID: choiceID, Stored: stored, Actual: counted,
+				})
+				mismatched[poll.ID] = true
+			}
+		}
+	}
+	sort.Slice(report.Mismatches, func(a, b int) bool {
+		if report.Mismatches[a].PollID != report.Mismatches[b].PollID {
+			return report.Mismatches[a].PollID < report.Mismatches[b].PollID
+		}
+		return report.Mismatches[a].ChoiceID < report.Mismatches[b].ChoiceID
+	})
+	if !repair || report.OK() {
+		return report, nil
+	}
+
+	for _, vote := range report.Orphans {
+		if err = i.r.DeleteVote(vote); err != nil {
+			i.l.Error("failed to delete orphan vote", zap.Any("vote", vote), zap.Error(err))
+			return report, fmt.Errorf("service: failed to delete orphan vote: %w", err)
+		}
+	}
+	for pollID := range mismatched {
+		if err = i.r.RecountVotes(pollID); err != nil {
+			i.l.Error("failed to recompute vote counts", zap.String("poll_id", pollID), zap.Error(err))
+			return report, fmt.Errorf("service: failed to recompute vote counts: %w", err)
+		}
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+func hasOption(poll *models.Poll, choiceID string) bool {
+	for _, option := range poll.Options {
+		if strconv.Itoa(option.ID) == choiceID {
+			return true
+		}
+	}
+	return false
+}
@@ -5,30 +5,109 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jaam8/mattermost_bot/internal/models"
-	"github.com/jaam8/mattermost_bot/internal/repository"
 	"go.uber.org/zap"
 	"strconv"
+	"time"
 )
 
+// purgeBatch is how many deleted polls are purged at once
+const purgeBatch = 100
+
+type Config struct {
+	ReminderCooldown time.Duration `yaml:"REMINDER_COOLDOWN" env:"REMINDER_COOLDOWN" env-default:"1h"`
+	// AuditRetention: audit entries older than this are deleted, zero keeps them forever
+	AuditRetention time.Duration `yaml:"AUDIT_RETENTION" env:"AUDIT_RETENTION" env-default:"2160h"`
+	// UndeleteWindow: deleted polls can be restored during this time, then they are purged with their votes
+	UndeleteWindow time.Duration   `yaml:"UNDELETE_WINDOW" env:"UNDELETE_WINDOW" env-default:"24h"`
+	Retention      RetentionConfig `yaml:"RETENTION" env:"RETENTION"`
+}
+
+// Publisher receives poll lifecycle events
+type Publisher interface {
+	Publish(event models.Event)
+}
+
+// PollStore keeps polls and their votes, it is implemented by the Tarantool and Postgres repositories.
+// Reads of a deleted poll fail with models.ErrPollNotFound except for the methods working with the trash
+type PollStore interface {
+	CreatePoll(poll *models.Poll) (string, []models.Option, error)
+	Vote(pollID, choiceID, userID string) error
+	Unvote(pollID, choiceID, userID string) error
+	GetPollResult(pollID string) (*models.Poll, error)
+	GetPollByPost(postID string) (*models.Poll, error)
+	ListPolls() ([]*models.Poll, error)
+	ListAllPolls() ([]*models.Poll, error)
+	SetPostID(pollID, postID, rootID string) error
+	SetReminded(pollID string) error
+	EndPoll(pollID, userID string) error
+	Deactivate(pollID string) error
+	DeletePoll(pollID, userID string, now time.Time) error
+	UndeletePoll(pollID, userID string, notBefore time.Time) error
+	DeletedBefore(t time.Time, limit uint32) ([]string, error)
+	PurgePoll(pollID string) error
+	SetEndedAt(pollID string, endedAt time.Time) error
+	SetPinned(pollID string, pinned bool) error
+	AnonymizeVotes(pollID string) error
+	GetVoters(pollID string) ([]string, error)
+	GetVotes(pollID string) ([]models.Vote, error)
+	ListAllVotes() ([]models.Vote, error)
+	DeleteVote(vote models.Vote) error
+	RecountVotes(pollID string) error
+}
+
+// ReminderStore keeps reminder opt-outs and the time of the last reminder of each user
+type ReminderStore interface {
+	// Remindable returns the users who have not opted out and were last reminded before remindedBefore
+	Remindable(userIDs []string, remindedBefore time.Time) ([]string, error)
+	SetOptOut(userID string, optOut bool) error
+	SetReminded(userID string, sentAt time.Time) error
+}
+
+// AuditStore keeps the append-only audit log
+type AuditStore interface {
+	Append(entry *models.AuditEntry) error
+	ListByPoll(pollID string) ([]*models.AuditEntry, error)
+	DeleteBefore(t time.Time, limit uint32) (int, error)
+	DeleteActions(pollID string, actions ...models.AuditAction) (int, error)
+}
+
 type PollService struct {
-	r repository.PollRepository
-	l *zap.Logger
+	r      PollStore
+	rm     ReminderStore
+	audit  AuditStore
+	events Publisher
+	l      *zap.Logger
+	cfg    Config
 }
 
-func New(r *repository.PollRepository, l *zap.Logger) *PollService {
+func New(r PollStore, rm ReminderStore, audit AuditStore, events Publisher, l *zap.Logger, cfg Config) *PollService {
 	return &PollService{
-		r: *r,
-		l: l,
+		r:      r,
+		rm:     rm,
+		audit:  audit,
+		events: events,
+		l:      l,
+		cfg:    cfg,
 	}
 }
 
-func (s *PollService) CreatePoll(question, creatorID string, optionsRaw []string) (string, []models.Option, error) {
+func (s *PollService) CreatePoll(question, creatorID, channelID string, optionsRaw []string,
+	settings models.Settings) (*models.Poll, error) {
 	s.l.Debug("creating poll", zap.String("question", question), zap.String("creatorID", creatorID), zap.Strings("options", optionsRaw))
+	if len(question) < 1 {
+		return nil, models.ErrQuestionIsEmpty
+	}
+	if len(optionsRaw) < 2 {
+		return nil, models.ErrNotEnoughOptions
+	}
+	if err := validateSettings(settings, len(optionsRaw)); err != nil {
+		return nil, err
+	}
 	options := make([]models.Option, len(optionsRaw))
 	votes := make(map[string]int)
 	for i, option := range optionsRaw {
 		if len(option) < 1 {
-			return "", nil, models.ErrOptionIsEmpty
+			return nil, models.ErrOptionIsEmpty
 		}
 		options[i] = models.Option{
 			ID:   i + 1,
@@ -44,24 +123,65 @@ func (s *PollService) CreatePoll(question, creatorID string, optionsRaw []string
 		Votes:     votes,
 		CreatorID: creatorID,
 		IsActive:  true,
+		ChannelID: channelID,
+		Settings:  settings,
 	}
 
-	id, options, err := s.r.CreatePoll(poll)
-	if err != nil {
+	if _, _, err := s.r.CreatePoll(poll); err != nil {
 		s.l.Error("failed to create poll", zap.Error(err))
-		return "", nil, fmt.Errorf("service: failed to create poll: %w", err)
+		s.record(models.AuditCreate, poll, "", creatorID, err)
+		return nil, fmt.Errorf("service: failed to create poll: %w", err)
+	}
+	s.record(models.AuditCreate, poll, poll.ID, creatorID, nil)
+	s.events.Publish(models.Event{Type: models.EventPollCreated, ActorID: creatorID, Poll: poll})
+	return poll, nil
+}
+
+// ListPolls returns polls filtered by channel and creator, empty filter matches any value
+func (s *PollService) ListPolls(channelID, creatorID string) ([]*models.Poll, error) {
+	polls, err := s.r.ListPolls()
+	if err != nil {
+		s.l.Error("failed to list polls", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to list polls: %w", err)
+	}
+	filtered := make([]*models.Poll, 0, len(polls))
+	for _, poll := range polls {
+		if channelID != "" && poll.ChannelID != channelID ||
+			creatorID != "" && poll.CreatorID != creatorID {
+			continue
+		}
+		filtered = append(filtered, poll)
+	}
+	return filtered, nil
+}
+
+// CountActivePolls returns the number of polls that are still open
+func (s *PollService) CountActivePolls() (int, error) {
+	polls, err := s.r.ListPolls()
+	if err != nil {
+		return 0, fmt.Errorf("service: failed to list polls: %w", err)
+	}
+	count := 0
+	for _, poll := range polls {
+		if poll.IsActive {
+			count++
+		}
 	}
-	return id, options, nil
+	return count, nil
 }
 
 func (s *PollService) Vote(pollID, choiceID, userID string) error {
 	err := s.r.Vote(pollID, choiceID, userID)
+	poll := s.votedPoll(pollID)
+	s.record(models.AuditVote, poll, pollID, userID, err)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrPollNotFound):
 			return err
 		case errors.Is(err, models.ErrVoteAlreadyExists):
 			return err
+		case errors.Is(err, models.ErrTooManyChoices):
+			return err
 		case errors.Is(err, models.ErrOptionIsNotFound):
 			return err
 		case errors.Is(err, models.ErrPollIsEnd):
@@ -71,28 +191,84 @@ func (s *PollService) Vote(pollID, choiceID, userID string) error {
 			return fmt.Errorf("service: failed to vote: %w", err)
 		}
 	}
+	s.publishVote(models.EventPollVoted, poll, userID)
 	return nil
 }
 
-func (s *PollService) GetPollResult(pollID string) (string, []models.Option, map[string]int, error) {
+func (s *PollService) Unvote(pollID, choiceID, userID string) error {
+	err := s.r.Unvote(pollID, choiceID, userID)
+	poll := s.votedPoll(pollID)
+	s.record(models.AuditUnvote, poll, pollID, userID, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound),
+			errors.Is(err, models.ErrVoteNotFound),
+			errors.Is(err, models.ErrOptionIsNotFound),
+			errors.Is(err, models.ErrPollIsEnd):
+			return err
+		default:
+			s.l.Error("failed to unvote", zap.Error(err))
+			return fmt.Errorf("service: failed to unvote: %w", err)
+		}
+	}
+	s.publishVote(models.EventPollUnvoted, poll, userID)
+	return nil
+}
+
+// votedPoll reads the poll after a vote once for both the audit entry and the vote event,
+// nil if it can not be read
+func (s *PollService) votedPoll(pollID string) *models.Poll {
+	poll, err := s.r.GetPollResult(pollID)
+	if err != nil {
+		s.l.Debug("failed to get voted poll", zap.String("poll_id", pollID), zap.Error(err))
+		return nil
+	}
+	return poll
+}
+
+func (s *PollService) publishVote(eventType models.EventType, poll *models.Poll, userID string) {
+	if poll == nil {
+		s.l.Warn("failed to get poll for vote event")
+		return
+	}
+	event := models.Event{Type: eventType, ActorID: userID, Poll: poll}
+	if poll.Settings.Anonymous {
+		event.ActorID = ""
+	}
+	s.events.Publish(event)
+}
+
+// PollByPost returns the poll announced in the post
+func (s *PollService) PollByPost(postID string) (*models.Poll, error) {
+	poll, err := s.r.GetPollByPost(postID)
+	if err != nil && !errors.Is(err, models.ErrPollNotFound) {
+		s.l.Error("failed to get poll by post", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to get poll by post: %w", err)
+	}
+	return poll, err
+}
+
+func (s *PollService) GetPollResult(pollID string) (*models.Poll, error) {
 	poll, err := s.r.GetPollResult(pollID)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrPollNotFound):
-			return "", nil, nil, err
+			return nil, err
 		case errors.Is(err, models.ErrFailedToProcessData):
-			return "", nil, nil, err
+			return nil, err
 		default:
 			s.l.Error("error getting poll result", zap.Error(err))
-			return "", nil, nil, fmt.Errorf("service: failed to get poll result: %w", err)
+			return nil, fmt.Errorf("service: failed to get poll result: %w", err)
 		}
 	}
 
-	return poll.Question, poll.Options, poll.Votes, nil
+	return poll, nil
 }
 
 func (s *PollService) DeletePoll(pollID, userID string) error {
-	err := s.r.DeletePoll(pollID, userID)
+	poll, _ := s.r.GetPollResult(pollID)
+	err := s.r.DeletePoll(pollID, userID, time.Now())
+	s.record(models.AuditDelete, poll, pollID, userID, err)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrPollNotFound):
@@ -104,23 +280,175 @@ func (s *PollService) DeletePoll(pollID, userID string) error {
 			return fmt.Errorf("service: failed to delete poll: %w", err)
 		}
 	}
+	s.events.Publish(models.Event{Type: models.EventPollDeleted, ActorID: userID, Poll: poll})
 	return nil
 }
 
-func (s *PollService) EndPoll(pollID, userID string) error {
-	err := s.r.EndPoll(pollID, userID)
+// UndeletePoll restores the poll deleted by its owner within the undelete window
+func (s *PollService) UndeletePoll(pollID, userID string) (*models.Poll, error) {
+	err := s.r.UndeletePoll(pollID, userID, time.Now().Add(-s.cfg.UndeleteWindow))
+	var poll *models.Poll
+	var getErr error
+	if err == nil {
+		poll, getErr = s.GetPollResult(pollID)
+	}
+	s.record(models.AuditUndelete, poll, pollID, userID, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound), errors.Is(err, models.ErrUserNotOwner),
+			errors.Is(err, models.ErrPollNotDeleted), errors.Is(err, models.ErrUndeleteExpired):
+			return nil, err
+		default:
+			s.l.Error("failed to undelete poll", zap.Error(err))
+			return nil, fmt.Errorf("service: failed to undelete poll: %w", err)
+		}
+	}
+	return poll, getErr
+}
+
+// UndeleteWindow returns how long deleted polls can be restored
+func (s *PollService) UndeleteWindow() time.Duration {
+	return s.cfg.UndeleteWindow
+}
+
+// PurgeDeleted removes polls deleted before the undelete window together with their votes,
+// returns the number of purged polls
+func (s *PollService) PurgeDeleted(now time.Time) (int, error) {
+	ids, err := s.r.DeletedBefore(now.Add(-s.cfg.UndeleteWindow), purgeBatch)
+	if err != nil {
+		s.l.Error("failed to list deleted polls", zap.Error(err))
+		return 0, fmt.Errorf("service: failed to list deleted polls: %w", err)
+	}
+	purged := 0
+	for _, id := range ids {
+		err = s.r.PurgePoll(id)
+		s.record(models.AuditPurge, nil, id, "", err)
+		if err != nil {
+			s.l.Error("failed to purge poll", zap.String("poll_id", id), zap.Error(err))
+			return purged, fmt.Errorf("service: failed to purge poll: %w", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// MembersCounter returns the number of members of the channel
+type MembersCounter func(channelID string) (int, error)
+
+// EndPoll ends the poll and evaluates its rules. Decision is nil if the poll has no rules
+func (s *PollService) EndPoll(pollID, userID string, countMembers MembersCounter) (*models.Poll, *models.Decision, error) {
+	members := 0
+	poll, err := s.r.GetPollResult(pollID)
+	if err == nil && poll.Settings.QuorumPercent > 0 && poll.IsActive && poll.CreatorID == userID {
+		members, err = countMembers(poll.ChannelID)
+		if err != nil {
+			s.l.Error("failed to count channel members", zap.Error(err))
+			return nil, nil, fmt.Errorf("service: failed to count channel members: %w", err)
+		}
+	}
+	err = s.r.EndPoll(pollID, userID)
+	s.record(models.AuditEnd, poll, pollID, userID, err)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrPollNotFound):
-			return err
+			return nil, nil, err
 		case errors.Is(err, models.ErrUserNotOwner):
-			return err
+			return nil, nil, err
 		case errors.Is(err, models.ErrPollAlreadyEnded):
-			return err
+			return nil, nil, err
 		default:
 			s.l.Error("failed to end poll", zap.Error(err))
-			return fmt.Errorf("service: failed to end poll: %w", err)
+			return nil, nil, fmt.Errorf("service: failed to end poll: %w", err)
+		}
+	}
+	return s.decide(pollID, userID, members)
+}
+
+// ClosePoll ends the poll when its deadline passed, ErrPollAlreadyEnded is returned
+// if the poll was ended meanwhile, then it is not decided again
+func (s *PollService) ClosePoll(pollID string, countMembers MembersCounter) (*models.Poll, *models.Decision, error) {
+	members := 0
+	poll, err := s.r.GetPollResult(pollID)
+	if err != nil {
+		s.l.Error("failed to get poll", zap.Error(err))
+		return nil, nil, fmt.Errorf("service: failed to get poll: %w", err)
+	}
+	if !poll.IsActive {
+		return nil, nil, models.ErrPollAlreadyEnded
+	}
+	if poll.Settings.QuorumPercent > 0 {
+		members, err = countMembers(poll.ChannelID)
+		if err != nil {
+			s.l.Error("failed to count channel members", zap.Error(err))
+			return nil, nil, fmt.Errorf("service: failed to count channel members: %w", err)
 		}
 	}
+	err = s.r.Deactivate(pollID)
+	if errors.Is(err, models.ErrPollAlreadyEnded) {
+		return nil, nil, err
+	}
+	s.record(models.AuditEnd, poll, pollID, "", err)
+	if err != nil {
+		s.l.Error("failed to close poll", zap.Error(err))
+		return nil, nil, fmt.Errorf("service: failed to close poll: %w", err)
+	}
+	return s.decide(pollID, "", members)
+}
+
+// decide evaluates rules of the ended poll and publishes the end event
+func (s *PollService) decide(pollID, actorID string, members int) (*models.Poll, *models.Decision, error) {
+	poll, err := s.r.GetPollResult(pollID)
+	if err != nil {
+		s.l.Error("failed to get ended poll", zap.Error(err))
+		return nil, nil, fmt.Errorf("service: failed to get ended poll: %w", err)
+	}
+	var decision *models.Decision
+	if poll.Settings.IsDecision() {
+		voters, err := s.r.GetVoters(pollID)
+		if err != nil {
+			s.l.Error("failed to get voters", zap.Error(err))
+			return nil, nil, fmt.Errorf("service: failed to get voters: %w", err)
+		}
+		decision = evaluate(poll, len(voters), members)
+		s.l.Debug("poll decision",
+			zap.String("poll_id", pollID),
+			zap.Any("decision", decision))
+	}
+	s.events.Publish(models.Event{Type: models.EventPollEnded, ActorID: actorID, Poll: poll, Decision: decision})
+	return poll, decision, nil
+}
+
+// SetPostID saves the poll post and the root of the poll thread
+func (s *PollService) SetPostID(pollID, postID, rootID string) error {
+	if err := s.r.SetPostID(pollID, postID, rootID); err != nil {
+		s.l.Error("failed to set poll post id", zap.Error(err))
+		return fmt.Errorf("service: failed to set poll post id: %w", err)
+	}
 	return nil
 }
+
+// Export returns the poll and its ballots for the owner or an admin, ballots are empty for anonymous polls
+func (s *PollService) Export(pollID, userID string, isAdmin bool) (*models.Poll, []models.Vote, error) {
+	poll, err := s.r.GetPollResult(pollID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			return nil, nil, err
+		default:
+			s.l.Error("failed to get poll", zap.Error(err))
+			return nil, nil, fmt.Errorf("service: failed to get poll: %w", err)
+		}
+	}
+	if poll.CreatorID != userID && !isAdmin {
+		return nil, nil, models.ErrUserNotOwner
+	}
+	if poll.Settings.Anonymous {
+		return poll, nil, nil
+	}
+	votes, err := s.r.GetVotes(pollID)
+	if err != nil {
+		s.l.Error("failed to get votes", zap.Error(err))
+		return nil, nil, fmt.Errorf("service: failed to get votes: %w", err)
+	}
+	return poll, votes, nil
+}
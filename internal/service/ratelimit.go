@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLimit is the key of the limit applied to subcommands without their own one
+const defaultLimit = "default"
+
+type RateLimitConfig struct {
+	// User and Channel: subcommand to limit like 5/1m, that is 5 commands per minute
+	User    map[string]string `yaml:"RATE_LIMIT_USER"    env:"RATE_LIMIT_USER"    env-default:"default:20/1m,create:5/1m" env-separator:","`
+	Channel map[string]string `yaml:"RATE_LIMIT_CHANNEL" env:"RATE_LIMIT_CHANNEL" env-default:"default:60/1m" env-separator:","`
+	// Store is memory or tarantool, the latter shares the limits between bot replicas
+	Store string `yaml:"RATE_LIMIT_STORE" env:"RATE_LIMIT_STORE" env-default:"memory"`
+}
+
+// BucketStore refills the buckets and takes a token from each of them only if all of them have one
+type BucketStore interface {
+	Take(buckets []models.Bucket, now time.Time) (bool, error)
+}
+
+type limit struct {
+	burst int
+	per   time.Duration
+}
+
+func (l limit) rate() float64 {
+	return float64(l.burst) / float64(l.per.Milliseconds())
+}
+
+// RateLimiter limits bot commands with token buckets per user and per channel
+type RateLimiter struct {
+	store   BucketStore
+	user    map[string]limit
+	channel map[string]limit
+}
+
+func NewRateLimiter(store BucketStore, cfg RateLimitConfig) (*RateLimiter, error) {
+	user, err := parseLimits(cfg.User)
+	if err != nil {
+		return nil, fmt.Errorf("service: invalid RATE_LIMIT_USER: %w", err)
+	}
+	channel, err := parseLimits(cfg.Channel)
+	if err != nil {
+		return nil, fmt.Errorf("service: invalid RATE_LIMIT_CHANNEL: %w", err)
+	}
+	return &RateLimiter{
+		store:   store,
+		user:    user,
+		channel: channel,
+	}, nil
+}
+
+// Allow takes a token from the user and the channel buckets of the subcommand, tokens are taken
+// only if both buckets have one, so a command denied by one bucket does not use up the other
+func (rl *RateLimiter) Allow(command, userID, channelID string) (bool, error) {
+	var buckets []models.Bucket
+	if l, ok := lookupLimit(rl.user, command); ok {
+		buckets = append(buckets, models.Bucket{Key: "user:" + userID + ":" + command, Rate: l.rate(), Burst: l.burst})
+	}
+	if l, ok := lookupLimit(rl.channel, command); ok {
+		buckets = append(buckets, models.Bucket{Key: "channel:" + channelID + ":" + command, Rate: l.rate(), Burst: l.burst})
+	}
+	if len(buckets) == 0 {
+		return true, nil
+	}
+	return rl.store.Take(buckets, time.Now())
+}
+
+func lookupLimit(limits map[string]limit, command string) (limit, bool) {
+	if l, ok := limits[command]; ok {
+		return l, l.burst > 0
+	}
+	l, ok := limits[defaultLimit]
+	return l, ok && l.burst > 0
+}
+
+// parseLimits parses limits like 5/1m, 0 turns the limit off
+func parseLimits(raw map[string]string) (map[string]limit, error) {
+	limits := make(map[string]limit, len(raw))
+	for command, value := range raw {
+		if value == "0" {
+			limits[command] = limit{}
+			continue
+		}
+		count, period, ok := strings.Cut(value, "/")
+		burst, err := strconv.Atoi(count)
+		if !ok || err != nil || burst < 0 {
+			return nil, fmt.Errorf("limit %q of %s should look like 5/1m", value, command)
+		}
+		per, err := time.ParseDuration(period)
+		if err != nil || per < time.Millisecond {
+			return nil, fmt.Errorf("limit %q of %s should look like 5/1m", value, command)
+		}
+		limits[command] = limit{burst: burst, per: per}
+	}
+	return limits, nil
+}
+
+// bucketSweepInterval is how often MemoryBuckets drops buckets which have refilled
+const bucketSweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// fullAt is when the bucket refills to burst, a full bucket is the same as a missing one
+	fullAt time.Time
+}
+
+// MemoryBuckets keeps token buckets in the process memory, buckets which have refilled
+// are dropped so the memory does not grow with the number of users and channels
+type MemoryBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryBuckets() *MemoryBuckets {
+	return &MemoryBuckets{buckets: make(map[string]*bucket)}
+}
+
+func (m *MemoryBuckets) Take(buckets []models.Bucket, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) >= bucketSweepInterval {
+		m.sweep(now)
+	}
+	states := make([]*bucket, len(buckets))
+	allowed := true
+	for i, limit := range buckets {
+		b, ok := m.buckets[limit.Key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Burst), updatedAt: now}
+			m.buckets[limit.Key] = b
+		}
+		elapsed := max(now.Sub(b.updatedAt).Milliseconds(), 0)
+		b.tokens = min(float64(limit.Burst), b.tokens+float64(elapsed)*limit.Rate)
+		b.updatedAt = now
+		allowed = allowed && b.tokens >= 1
+		states[i] = b
+	}
+	for i, limit := range buckets {
+		b := states[i]
+		if allowed {
+			b.tokens--
+		}
+		b.fullAt = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Millisecond)))
+	}
+	return allowed, nil
+}
+
+// sweep drops the buckets which have refilled to burst by now
+func (m *MemoryBuckets) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
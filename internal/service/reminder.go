@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// MembersLister returns ids of the channel members
+type MembersLister func(channelID string) ([]string, error)
+
+// Remind returns members of the poll channel who have not voted yet and can be reminded now.
+// Only the poll owner can request a reminder
+func (s *PollService) Remind(pollID, userID string, listMembers MembersLister) (*models.Poll, []string, error) {
+	poll, err := s.r.GetPollResult(pollID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound):
+			return nil, nil, err
+		default:
+			s.l.Error("failed to get poll", zap.Error(err))
+			return nil, nil, fmt.Errorf("service: failed to get poll: %w", err)
+		}
+	}
+	if poll.CreatorID != userID {
+		return nil, nil, models.ErrUserNotOwner
+	}
+	if !poll.IsActive {
+		return nil, nil, models.ErrPollIsEnd
+	}
+	targets, err := s.reminderTargets(poll, listMembers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return poll, targets, nil
+}
+
+// AutoRemind returns reminder targets for the poll approaching its deadline,
+// the poll is marked by MarkPollReminded once the reminders are delivered
+func (s *PollService) AutoRemind(poll *models.Poll, listMembers MembersLister) ([]string, error) {
+	return s.reminderTargets(poll, listMembers)
+}
+
+// MarkPollReminded stops automatic reminders of the poll
+func (s *PollService) MarkPollReminded(pollID string) error {
+	if err := s.r.SetReminded(pollID); err != nil {
+		s.l.Error("failed to mark poll as reminded", zap.Error(err))
+		return fmt.Errorf("service: failed to mark poll as reminded: %w", err)
+	}
+	return nil
+}
+
+// DuePolls returns active polls which need the automatic reminder and polls with passed deadline
+func (s *PollService) DuePolls(now time.Time) ([]*models.Poll, []*models.Poll, error) {
+	polls, err := s.r.ListPolls()
+	if err != nil {
+		s.l.Error("failed to list polls", zap.Error(err))
+		return nil, nil, fmt.Errorf("service: failed to list polls: %w", err)
+	}
+	var remind, expired []*models.Poll
+	for _, poll := range polls {
+		deadline := poll.Settings.Deadline
+		if !poll.IsActive || deadline == nil {
+			continue
+		}
+		switch {
+		case !now.Before(*deadline):
+			expired = append(expired, poll)
+		case !poll.Reminded && poll.Settings.RemindBefore > 0 &&
+			!now.Before(deadline.Add(-poll.Settings.RemindBefore)):
+			remind = append(remind, poll)
+		}
+	}
+	return remind, expired, nil
+}
+
+// MarkReminded starts the reminder cooldown of the user, it is called after the reminder is delivered.
+// The cooldown is per user and not per poll, so members of many polls are not flooded with reminders
+func (s *PollService) MarkReminded(userID string, at time.Time) error {
+	if err := s.rm.SetReminded(userID, at); err != nil {
+		s.l.Error("failed to save reminder", zap.Error(err))
+		return fmt.Errorf("service: failed to save reminder: %w", err)
+	}
+	return nil
+}
+
+func (s *PollService) SetReminderOptOut(userID string, optOut bool) error {
+	if err := s.rm.SetOptOut(userID, optOut); err != nil {
+		s.l.Error("failed to set reminder opt-out", zap.Error(err))
+		return fmt.Errorf("service: failed to set reminder opt-out: %w", err)
+	}
+	return nil
+}
+
+// reminderTargets skips voters, opted out users and users reminded within the cooldown,
+// the last two are loaded for all members in one query
+func (s *PollService) reminderTargets(poll *models.Poll, listMembers MembersLister) ([]string, error) {
+	members, err := listMembers(poll.ChannelID)
+	if err != nil {
+		s.l.Error("failed to list channel members", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to list channel members: %w", err)
+	}
+	voters, err := s.r.GetVoters(poll.ID)
+	if err != nil {
+		s.l.Error("failed to get voters", zap.Error(err))
+		return nil, fmt.Errorf("service: failed to get voters: %w", err)
+	}
+	voted := make(map[string]bool, len(voters))
+	for _, voter := range voters {
+		voted[voter] = true
+	}
+	var notVoted []string
+	for _, member := range members {
+		if !voted[member] {
+			notVoted = append(notVoted, member)
+		}
+	}
+	var targets []string
+	if len(notVoted) > 0 {
+		targets, err = s.rm.Remindable(notVoted, time.Now().Add(-s.cfg.ReminderCooldown))
+		if err != nil {
+			s.l.Error("failed to get remindable users", zap.Error(err))
+			return nil, fmt.Errorf("service: failed to get remindable users: %w", err)
+		}
+	}
+	s.l.Debug("reminder targets",
+		zap.String("poll_id", poll.ID),
+		zap.Int("members", len(members)),
+		zap.Int("voters", len(voters)),
+		zap.Strings("targets", targets))
+	return targets, nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// retentionProgressEvery is how many processed polls are logged as progress of one run
+const retentionProgressEvery = 100
+
+type RetentionConfig struct {
+	// Polls: ended polls are deleted with their votes after this time, zero keeps them forever
+	Polls time.Duration `yaml:"POLL_RETENTION" env:"POLL_RETENTION" env-default:"0"`
+	// Voters: voter ids of ended polls are anonymized after this time, zero keeps them
+	Voters   time.Duration `yaml:"VOTER_RETENTION"    env:"VOTER_RETENTION" env-default:"0"`
+	Interval time.Duration `yaml:"RETENTION_INTERVAL" env:"RETENTION_INTERVAL" env-default:"1h"`
+}
+
+// RetentionResult counts polls changed by one retention run
+type RetentionResult struct {
+	Purged     int
+	Anonymized int
+	Stamped    int
+}
+
+// RunRetention enforces the retention policy every interval until ctx is done
+func (s *PollService) RunRetention(ctx context.Context) {
+	if s.cfg.Retention.Polls <= 0 && s.cfg.Retention.Voters <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.Retention.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.EnforceRetention(ctx, now); err != nil {
+				s.l.Error("retention run failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// EnforceRetention deletes ended polls and anonymizes their voters when the retention periods
+// have passed, pinned polls are skipped. Polls ended before the end time was stored get it now,
+// so their retention starts from the first run
+func (s *PollService) EnforceRetention(ctx context.Context, now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+	polls, err := s.r.ListPolls()
+	if err != nil {
+		s.l.Error("failed to list polls", zap.Error(err))
+		return result, fmt.Errorf("service: failed to list polls: %w", err)
+	}
+	var ended []*models.Poll
+	for _, poll := range polls {
+		if !poll.IsActive && !poll.Pinned {
+			ended = append(ended, poll)
+		}
+	}
+	s.l.Info("retention run started",
+		zap.Int("polls", len(polls)),
+		zap.Int("candidates", len(ended)))
+	for i, poll := range ended {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if i > 0 && i%retentionProgressEvery == 0 {
+			s.l.Info("retention run progress",
+				zap.Int("processed", i),
+				zap.Int("total", len(ended)),
+				zap.Int("purged", result.Purged),
+				zap.Int("anonymized", result.Anonymized))
+		}
+		if poll.EndedAt == nil {
+			if err = s.r.SetEndedAt(poll.ID, now); err != nil {
+				s.l.Error("failed to set poll end time", zap.String("poll_id", poll.ID), zap.Error(err))
+				return result, fmt.Errorf("service: failed to set poll end time: %w", err)
+			}
+			result.Stamped++
+			continue
+		}
+		age := now.Sub(*poll.EndedAt)
+		switch {
+		case s.cfg.Retention.Polls > 0 && age >= s.cfg.Retention.Polls:
+			err = s.r.PurgePoll(poll.ID)
+			s.record(models.AuditPurge, poll, poll.ID, "", err)
+			if err != nil {
+				s.l.Error("failed to purge poll", zap.String("poll_id", poll.ID), zap.Error(err))
+				return result, fmt.Errorf("service: failed to purge poll: %w", err)
+			}
+			result.Purged++
+			if err = s.forgetVoters(poll.ID); err != nil {
+				return result, err
+			}
+		case s.cfg.Retention.Voters > 0 && age >= s.cfg.Retention.Voters && !poll.Anonymized:
+			err = s.r.AnonymizeVotes(poll.ID)
+			s.record(models.AuditAnonymize, poll, poll.ID, "", err)
+			if err != nil {
+				s.l.Error("failed to anonymize votes", zap.String("poll_id", poll.ID), zap.Error(err))
+				return result, fmt.Errorf("service: failed to anonymize votes: %w", err)
+			}
+			result.Anonymized++
+			if err = s.forgetVoters(poll.ID); err != nil {
+				return result, err
+			}
+		}
+	}
+	s.l.Info("retention run finished",
+		zap.Int("purged", result.Purged),
+		zap.Int("anonymized", result.Anonymized),
+		zap.Int("stamped", result.Stamped))
+	return result, nil
+}
+
+// forgetVoters deletes vote and unvote audit entries of the poll, which keep voter ids.
+// The entries can not be changed, so they are deleted once the votes are anonymized or purged,
+// a failed anonymization keeps them and entries left by a failed deletion expire with the audit log
+func (s *PollService) forgetVoters(pollID string) error {
+	if s.audit == nil {
+		return nil
+	}
+	deleted, err := s.audit.DeleteActions(pollID, models.AuditVote, models.AuditUnvote)
+	if err != nil {
+		s.l.Error("failed to delete vote audit entries", zap.String("poll_id", pollID), zap.Error(err))
+		return fmt.Errorf("service: failed to delete vote audit entries: %w", err)
+	}
+	s.l.Debug("deleted vote audit entries", zap.String("poll_id", pollID), zap.Int("deleted", deleted))
+	return nil
+}
+
+// PinPoll keeps the poll from the retention policy or releases it, available to the owner and admins
+func (s *PollService) PinPoll(pollID, userID string, isAdmin, pinned bool) error {
+	action := models.AuditPin
+	if !pinned {
+		action = models.AuditUnpin
+	}
+	poll, err := s.r.GetPollResult(pollID)
+	if err == nil && poll.CreatorID != userID && !isAdmin {
+		err = models.ErrUserNotOwner
+	}
+	if err == nil {
+		err = s.r.SetPinned(pollID, pinned)
+	}
+	s.record(action, poll, pollID, userID, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPollNotFound), errors.Is(err, models.ErrUserNotOwner):
+			return err
+		default:
+			s.l.Error("failed to pin poll", zap.Error(err))
+			return fmt.Errorf("service: failed to pin poll: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jaam8/mattermost_bot/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	deliveryBatch = 100
+	maxBackoff    = time.Hour
+)
+
+type WebhookConfig struct {
+	URLs []string `yaml:"WEBHOOK_URLS" env:"WEBHOOK_URLS" env-separator:","`
+	// Events: event types to send, all events if empty
+	Events      []string      `yaml:"WEBHOOK_EVENTS"       env:"WEBHOOK_EVENTS" env-separator:","`
+	Secret      string        `yaml:"WEBHOOK_SECRET"       env:"WEBHOOK_SECRET"`
+	MaxAttempts int           `yaml:"WEBHOOK_MAX_ATTEMPTS" env:"WEBHOOK_MAX_ATTEMPTS" env-default:"8"`
+	Interval    time.Duration `yaml:"WEBHOOK_INTERVAL"     env:"WEBHOOK_INTERVAL" env-default:"5s"`
+	Timeout     time.Duration `yaml:"WEBHOOK_TIMEOUT"      env:"WEBHOOK_TIMEOUT" env-default:"10s"`
+}
+
+// DeliveryStore keeps the queue of webhook deliveries
+type DeliveryStore interface {
+	Enqueue(delivery *models.Delivery) error
+	Due(now time.Time, limit uint32) ([]*models.Delivery, error)
+	Reschedule(id string, attempts int, next time.Time) error
+	Delete(id string) error
+}
+
+// Webhooks queues poll events for subscribed urls and delivers them with retries
+type Webhooks struct {
+	r      DeliveryStore
+	l      *zap.Logger
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+func NewWebhooks(r DeliveryStore, l *zap.Logger, cfg WebhookConfig) *Webhooks {
+	return &Webhooks{
+		r:      r,
+		l:      l,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Publish queues the event for every subscribed url, errors are only logged
+// so that webhooks never break poll commands
+func (w *Webhooks) Publish(event models.Event) {
+	if len(w.cfg.URLs) == 0 {
+		return
+	}
+	if len(w.cfg.Events) > 0 && !slices.Contains(w.cfg.Events, string(event.Type)) {
+		return
+	}
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now().UTC()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		w.l.Error("failed to marshal webhook event", zap.Error(err))
+		return
+	}
+	for _, url := range w.cfg.URLs {
+		delivery := &models.Delivery{
+			ID:            uuid.New().String(),
+			URL:           url,
+			Event:         event.Type,
+			Payload:       string(payload),
+			NextAttemptAt: time.Now(),
+		}
+		if err = w.r.Enqueue(delivery); err != nil {
+			w.l.Error("failed to enqueue webhook delivery",
+				zap.String("url", url),
+				zap.String("event", string(event.Type)),
+				zap.Error(err))
+		}
+	}
+}
+
+// Run delivers queued events until ctx is done
+func (w *Webhooks) Run(ctx context.Context) {
+	if len(w.cfg.URLs) == 0 {
+		return
+	}
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.deliverDue(ctx, now)
+		}
+	}
+}
+
+func (w *Webhooks) deliverDue(ctx context.Context, now time.Time) {
+	deliveries, err := w.r.Due(now, deliveryBatch)
+	if err != nil {
+		w.l.Error("failed to get due webhook deliveries", zap.Error(err))
+		return
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		w.deliver(ctx, delivery)
+	}
+}
+
+func (w *Webhooks) deliver(ctx context.Context, delivery *models.Delivery) {
+	err := w.send(ctx, delivery)
+	if err == nil {
+		w.l.Debug("webhook delivered",
+			zap.String("delivery_id", delivery.ID),
+			zap.String("url", delivery.URL))
+		if err = w.r.Delete(delivery.ID); err != nil {
+			w.l.Error("failed to delete webhook delivery", zap.Error(err))
+		}
+		return
+	}
+	attempts := delivery.Attempts + 1
+	if attempts >= w.cfg.MaxAttempts {
+		w.l.Error("webhook delivery dropped after max attempts",
+			zap.String("delivery_id", delivery.ID),
+			zap.String("url", delivery.URL),
+			zap.Int("attempts", attempts),
+			zap.Error(err))
+		if err = w.r.Delete(delivery.ID); err != nil {
+			w.l.Error("failed to delete webhook delivery", zap.Error(err))
+		}
+		return
+	}
+	next := time.Now().Add(backoff(w.cfg.Interval, attempts))
+	w.l.Warn("webhook delivery failed, retrying",
+		zap.String("delivery_id", delivery.ID),
+		zap.String("url", delivery.URL),
+		zap.Int("attempts", attempts),
+		zap.Time("next_attempt_at", next),
+		zap.Error(err))
+	if err = w.r.Reschedule(delivery.ID, attempts, next); err != nil {
+		w.l.Error("failed to reschedule webhook delivery", zap.Error(err))
+	}
+}
+
+func (w *Webhooks) send(ctx context.Context, delivery *models.Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL,
+		bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Poll-Event", string(delivery.Event))
+	req.Header.Set("X-Poll-Delivery", delivery.ID)
+	if w.cfg.Secret != "" {
+		req.Header.Set("X-Poll-Signature", "sha256="+sign(w.cfg.Secret, delivery.Payload))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("service: webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign returns hex encoded HMAC-SHA256 of the payload
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the interval for every failed attempt
+func backoff(interval time.Duration, attempts int) time.Duration {
+	delay := interval
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
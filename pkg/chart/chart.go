@@ -0,0 +1,150 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+const (
+	width       = 800
+	padding     = 20
+	titleHeight = 40
+	rowHeight   = 36
+	barHeight   = 22
+	maxLabel    = 280
+	valueWidth  = 110
+)
+
+var (
+	background = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	track      = color.RGBA{R: 0xee, G: 0xf0, B: 0xf3, A: 0xff}
+	text       = color.RGBA{R: 0x3d, G: 0x3c, B: 0x40, A: 0xff}
+	palette    = []color.RGBA{
+		{R: 0x16, G: 0x5e, B: 0xc0, A: 0xff},
+		{R: 0x06, G: 0xa3, B: 0x7f, A: 0xff},
+		{R: 0xf5, G: 0xab, B: 0x00, A: 0xff},
+		{R: 0xd2, G: 0x4b, B: 0x4e, A: 0xff},
+		{R: 0x7a, G: 0x5a, B: 0xb8, A: 0xff},
+		{R: 0x1c, G: 0x9b, B: 0xc4, A: 0xff},
+	}
+)
+
+type Bar struct {
+	Label string
+	Value int
+}
+
+// HorizontalBars renders a png bar chart with one row per bar
+func HorizontalBars(title string, bars []Bar) ([]byte, error) {
+	regular, err := newFace(goregular.TTF, 14)
+	if err != nil {
+		return nil, err
+	}
+	defer regular.Close()
+	bold, err := newFace(gobold.TTF, 16)
+	if err != nil {
+		return nil, err
+	}
+	defer bold.Close()
+
+	total := 0
+	labelWidth := 0
+	for _, bar := range bars {
+		total += bar.Value
+		w := font.MeasureString(regular, bar.Label).Ceil()
+		if w > labelWidth {
+			labelWidth = w
+		}
+	}
+	if labelWidth > maxLabel {
+		labelWidth = maxLabel
+	}
+
+	height := padding*2 + titleHeight + rowHeight*len(bars)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	drawText(img, bold, truncate(bold, title, width-padding*2), padding, padding+20)
+
+	barX := padding + labelWidth + padding
+	barWidth := width - barX - valueWidth - padding
+	for i, bar := range bars {
+		y := padding + titleHeight + i*rowHeight
+		drawText(img, regular, truncate(regular, bar.Label, labelWidth), padding, y+barHeight-6)
+
+		fill(img, image.Rect(barX, y, barX+barWidth, y+barHeight), track)
+		if total > 0 && bar.Value > 0 {
+			w := barWidth * bar.Value / total
+			if w < 2 {
+				w = 2
+			}
+			fill(img, image.Rect(barX, y, barX+w, y+barHeight), palette[i%len(palette)])
+		}
+
+		percent := 0
+		if total > 0 {
+			percent = bar.Value * 100 / total
+		}
+		drawText(img, regular, fmt.Sprintf("%d (%d%%)", bar.Value, percent),
+			barX+barWidth+padding/2, y+barHeight-6)
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("chart: failed to encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("chart: failed to parse font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("chart: failed to create font face: %w", err)
+	}
+	return face, nil
+}
+
+func drawText(img draw.Image, face font.Face, s string, x, y int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(text),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func fill(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// truncate cuts the string with ellipsis to fit into maxWidth pixels
+func truncate(face font.Face, s string, maxWidth int) string {
+	if font.MeasureString(face, s).Ceil() <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if font.MeasureString(face, string(runes)+"…").Ceil() <= maxWidth {
+			break
+		}
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Config struct {
+	Path        string        `yaml:"SQLITE_PATH"         env:"SQLITE_PATH" env-default:"data/mattermost_bot.db"`
+	BusyTimeout time.Duration `yaml:"SQLITE_BUSY_TIMEOUT" env:"SQLITE_BUSY_TIMEOUT" env-default:"5s"`
+}
+
+// New opens the database file in WAL mode with foreign keys on, transactions take the write lock
+// when they begin so that a read followed by a write in one transaction does not fail with SQLITE_BUSY
+func New(config Config) (*sql.DB, error) {
+	if dir := filepath.Dir(config.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to create directory: %w", err)
+		}
+	}
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.BusyTimeout.Milliseconds()))
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+config.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to open %s: %w", config.Path, err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: failed to open %s: %w", config.Path, err)
+	}
+	return db, nil
+}
@@ -1,8 +1,18 @@
 package tarantool
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/tarantool/go-tarantool"
-	"log"
+	"github.com/tarantool/go-tarantool/multi"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	startupBackoffMin = 500 * time.Millisecond
+	startupBackoffMax = 10 * time.Second
 )
 
 type Config struct {
@@ -10,16 +20,116 @@ type Config struct {
 	Port     string `yaml:"TARANTOOL_PORT" env:"TARANTOOL_PORT" env-default:"3301"`
 	Username string `yaml:"TARANTOOL_USER" env:"TARANTOOL_USER" env-default:"admin"`
 	Password string `yaml:"TARANTOOL_PASSWORD" env:"TARANTOOL_PASSWORD" env-default:"secret"`
+	// Addrs: host:port of replica set instances, requests go to the first available one,
+	// Host and Port are used when it is empty
+	Addrs []string `yaml:"TARANTOOL_ADDRS" env:"TARANTOOL_ADDRS" env-separator:","`
+	// Timeout: a request fails if there is no response in this time, zero waits forever
+	Timeout time.Duration `yaml:"TARANTOOL_TIMEOUT" env:"TARANTOOL_TIMEOUT" env-default:"5s"`
+	// Reconnect: pause between attempts to restore a broken connection, zero does not reconnect
+	Reconnect time.Duration `yaml:"TARANTOOL_RECONNECT" env:"TARANTOOL_RECONNECT" env-default:"1s"`
+	// MaxReconnects: the connection is closed after this number of failed attempts, zero tries forever
+	MaxReconnects uint `yaml:"TARANTOOL_MAX_RECONNECTS" env:"TARANTOOL_MAX_RECONNECTS" env-default:"0"`
+	// StartupTimeout: how long to retry the first connection while Tarantool is booting
+	StartupTimeout time.Duration `yaml:"TARANTOOL_STARTUP_TIMEOUT" env:"TARANTOOL_STARTUP_TIMEOUT" env-default:"1m"`
+}
+
+// New connects to Tarantool, the first connection is retried with exponential backoff
+// until StartupTimeout passes or ctx is done. Several Addrs are served by one multi connection
+// which switches to another instance when the current one is unavailable
+func New(ctx context.Context, config Config, l *zap.Logger) (tarantool.Connector, error) {
+	addrs := config.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{config.Host + ":" + config.Port}
+	}
+	opts := tarantool.Opts{
+		User:          config.Username,
+		Pass:          config.Password,
+		Timeout:       config.Timeout,
+		Reconnect:     config.Reconnect,
+		MaxReconnects: config.MaxReconnects,
+		Logger:        logger{l: l},
+	}
+	deadline := time.Now().Add(config.StartupTimeout)
+	backoff := startupBackoffMin
+	for attempt := 1; ; attempt++ {
+		conn, err := connect(addrs, opts)
+		if err == nil {
+			l.Info("connected to Tarantool", zap.Strings("addrs", addrs), zap.Int("attempt", attempt))
+			return conn, nil
+		}
+		if isAuthError(err) || time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("tarantool: failed to connect to %v after %d attempts: %w", addrs, attempt, err)
+		}
+		l.Warn("failed to connect to Tarantool, retrying",
+			zap.Strings("addrs", addrs),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("tarantool: failed to connect to %v: %w", addrs, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, startupBackoffMax)
+	}
 }
 
-func New(config Config) (*tarantool.Connection, error) {
-	conn, err := tarantool.Connect(config.Host+":"+config.Port, tarantool.Opts{
-		User: config.Username,
-		Pass: config.Password,
-	})
+func connect(addrs []string, opts tarantool.Opts) (tarantool.Connector, error) {
+	if len(addrs) > 1 {
+		conn, err := multi.Connect(addrs, opts)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	conn, err := tarantool.Connect(addrs[0], opts)
 	if err != nil {
-		log.Fatalf("failed connect to Tarantool: %v", err)
 		return nil, err
 	}
+	// with Reconnect set an unreachable instance is not an error, the connection keeps trying in background
+	if !conn.ConnectedNow() {
+		conn.Close()
+		return nil, errors.New("instance is not reachable")
+	}
 	return conn, nil
 }
+
+func isAuthError(err error) bool {
+	var terr tarantool.Error
+	return errors.As(err, &terr) && (terr.Code == tarantool.ErrNoSuchUser || terr.Code == tarantool.ErrPasswordMismatch)
+}
+
+// Close closes the connection, a single connection waits for the requests in flight
+func Close(conn tarantool.Connector) error {
+	if c, ok := conn.(*tarantool.Connection); ok {
+		return c.CloseGraceful()
+	}
+	return conn.Close()
+}
+
+// logger reports connection events of the client to zap instead of the standard logger
+type logger struct {
+	l *zap.Logger
+}
+
+func (lg logger) Report(event tarantool.ConnLogKind, conn *tarantool.Connection, v ...interface{}) {
+	switch event {
+	case tarantool.LogReconnectFailed:
+		reconnects, _ := v[0].(uint)
+		err, _ := v[1].(error)
+		lg.l.Warn("tarantool reconnect failed",
+			zap.String("addr", conn.Addr()),
+			zap.Uint("attempt", reconnects),
+			zap.Error(err))
+	case tarantool.LogLastReconnectFailed:
+		err, _ := v[0].(error)
+		lg.l.Error("tarantool last reconnect failed, connection is closed",
+			zap.String("addr", conn.Addr()),
+			zap.Error(err))
+	default:
+		lg.l.Warn("tarantool connection event",
+			zap.Int("event", int(event)),
+			zap.String("addr", conn.Addr()),
+			zap.Any("details", v))
+	}
+}
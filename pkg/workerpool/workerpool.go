@@ -0,0 +1,73 @@
+package workerpool
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+type Config struct {
+	Workers   int `yaml:"WORKERS"      env:"WORKERS"      env-default:"8"`
+	QueueSize int `yaml:"WORKER_QUEUE" env:"WORKER_QUEUE" env-default:"100"`
+}
+
+// Pool runs tasks on a fixed number of workers, tasks with the same key run
+// on the same worker in the order they were submitted
+type Pool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func New(config Config) *Pool {
+	workers := max(config.Workers, 1)
+	p := &Pool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), max(config.QueueSize/workers, 1))
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// Submit queues the task, it blocks while the worker queue is full and returns ctx error
+// if ctx is done first
+func (p *Pool) Submit(ctx context.Context, key string, task func()) error {
+	select {
+	case p.queues[p.index(key)] <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting tasks and waits until queued ones are done or ctx is done,
+// it must not be called while Submit is running
+func (p *Pool) Close(ctx context.Context) error {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work(queue chan func()) {
+	defer p.wg.Done()
+	for task := range queue {
+		task()
+	}
+}
+
+func (p *Pool) index(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
@@ -1,4 +1,5 @@
 local app_name = "mattermost_bot"
+local json = require('json')
 
 box.cfg{
     listen = os.getenv("TARANTOOL_PORT")
@@ -45,6 +46,400 @@ box.once('bootstrap_schema', function()
     })
 end)
 
+box.once('polls_channel_and_settings', function()
+    box.space.polls:format({
+        {name = 'id',         type = 'string'},
+        {name = 'question',   type = 'string'},
+        {name = 'options',    type = 'array'},
+        {name = 'votes',      type = 'string'},
+        {name = 'creator_id', type = 'string'},
+        {name = 'is_active',  type = 'boolean'},
+        {name = 'channel_id', type = 'string', is_nullable = true},
+        {name = 'settings',   type = 'string', is_nullable = true},
+    })
+end)
+
+-- add_fields appends nullable fields to the space format
+local function add_fields(space, fields)
+    local format = space:format()
+    for _, field in ipairs(fields) do
+        field.is_nullable = true
+        table.insert(format, field)
+    end
+    space:format(format)
+end
+
+-- set_poll_fields replaces the poll with the fields set by name, polls written before
+-- a field was added are padded with nulls up to it
+local function set_poll_fields(poll, values)
+    local tuple = poll:totable()
+    local last = #tuple
+    for i, field in ipairs(box.space.polls:format()) do
+        if values[field.name] ~= nil then
+            tuple[i] = values[field.name]
+            last = math.max(last, i)
+        end
+    end
+    for i = 1, last do
+        if tuple[i] == nil then
+            tuple[i] = box.NULL
+        end
+    end
+    box.space.polls:replace(tuple)
+end
+
+box.once('reminders', function()
+    add_fields(box.space.polls, {
+        {name = 'post_id',  type = 'string'},
+        {name = 'reminded', type = 'boolean'},
+    })
+    box.space.votes:create_index('poll', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {'poll_id'}
+    })
+
+    local optouts_space = box.schema.space.create('reminder_optouts', {
+        if_not_exists = true,
+        format = {
+            {name = 'user_id', type = 'string'},
+        }
+    })
+    optouts_space:create_index('primary', {
+        if_not_exists = true,
+        type = 'hash',
+        parts = {'user_id'}
+    })
+
+    local reminders_space = box.schema.space.create('reminders', {
+        if_not_exists = true,
+        format = {
+            {name = 'user_id', type = 'string'},
+            {name = 'sent_at', type = 'unsigned'},
+        }
+    })
+    reminders_space:create_index('primary', {
+        if_not_exists = true,
+        type = 'hash',
+        parts = {'user_id'}
+    })
+end)
+
+box.once('webhook_deliveries', function()
+    local deliveries_space = box.schema.space.create('webhook_deliveries', {
+        if_not_exists = true,
+        format = {
+            {name = 'id',              type = 'string'},
+            {name = 'url',             type = 'string'},
+            {name = 'event',           type = 'string'},
+            {name = 'payload',         type = 'string'},
+            {name = 'attempts',        type = 'unsigned'},
+            {name = 'next_attempt_at', type = 'unsigned'},
+        }
+    })
+    deliveries_space:create_index('primary', {
+        if_not_exists = true,
+        type = 'hash',
+        parts = {'id'}
+    })
+    deliveries_space:create_index('next_attempt', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {'next_attempt_at'}
+    })
+end)
+
+box.once('rate_limits', function()
+    local rate_limits_space = box.schema.space.create('rate_limits', {
+        if_not_exists = true,
+        format = {
+            {name = 'key',        type = 'string'},
+            {name = 'tokens',     type = 'number'},
+            {name = 'updated_at', type = 'unsigned'},
+            {name = 'full_at',    type = 'unsigned'},
+        }
+    })
+    rate_limits_space:create_index('primary', {
+        if_not_exists = true,
+        type = 'hash',
+        parts = {'key'}
+    })
+    rate_limits_space:create_index('full', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {'full_at'}
+    })
+end)
+
+box.once('multiple_choice', function()
+    -- a user may vote for several options, so the votes are unique per choice
+    box.space.votes.index.user_poll:alter({
+        type = 'tree',
+        unique = false,
+        parts = {'poll_id', 'user_id'}
+    })
+    box.space.votes.index.primary:alter({
+        parts = {'poll_id', 'user_id', 'choice_id'}
+    })
+end)
+
+box.once('reactions', function()
+    box.space.polls:create_index('post', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {{field = 'post_id', type = 'string', is_nullable = true}}
+    })
+end)
+
+box.once('threads', function()
+    add_fields(box.space.polls, {
+        {name = 'root_id', type = 'string'},
+    })
+end)
+
+box.once('audit', function()
+    local audit_space = box.schema.space.create('audit', {
+        if_not_exists = true,
+        format = {
+            {name = 'id',         type = 'string'},
+            {name = 'poll_id',    type = 'string'},
+            {name = 'action',     type = 'string'},
+            {name = 'actor_id',   type = 'string'},
+            {name = 'channel_id', type = 'string'},
+            {name = 'outcome',    type = 'string'},
+            {name = 'created_at', type = 'unsigned'},
+        }
+    })
+    audit_space:create_index('primary', {
+        if_not_exists = true,
+        type = 'hash',
+        parts = {'id'}
+    })
+    audit_space:create_index('poll', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {'poll_id', 'created_at'}
+    })
+    audit_space:create_index('created', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {'created_at'}
+    })
+end)
+
+-- audit entries are append-only: they can be inserted and deleted by retention, but never changed
+box.space.audit:before_replace(function(old, new)
+    if old ~= nil and new ~= nil then
+        error('audit entries are append-only')
+    end
+end)
+
+box.once('soft_delete', function()
+    add_fields(box.space.polls, {
+        {name = 'deleted_at', type = 'unsigned'},
+    })
+    box.space.polls:create_index('deleted', {
+        if_not_exists = true,
+        type = 'tree',
+        unique = false,
+        parts = {{field = 'deleted_at', type = 'unsigned', is_nullable = true}}
+    })
+end)
+
+-- purge_poll removes the poll with all its votes in one transaction
+function purge_poll(poll_id)
+    box.atomic(function()
+        local keys = {}
+        for _, vote in box.space.votes.index.poll:pairs({poll_id}) do
+            table.insert(keys, {vote[1], vote[2], vote[3]})
+        end
+        for _, key in ipairs(keys) do
+            box.space.votes:delete(key)
+        end
+        box.space.polls:delete({poll_id})
+    end)
+end
+
+box.once('retention', function()
+    add_fields(box.space.polls, {
+        {name = 'ended_at',   type = 'unsigned'},
+        {name = 'pinned',     type = 'boolean'},
+        {name = 'anonymized', type = 'boolean'},
+    })
+end)
+
+-- anonymize_votes replaces voter ids of the poll with anon:N in one transaction, one voter
+-- gets the same id for all choices so the number of voters is kept
+function anonymize_votes(poll_id)
+    box.atomic(function()
+        local votes = box.space.votes.index.poll:select({poll_id})
+        local ids, count = {}, 0
+        for _, vote in ipairs(votes) do
+            local anon = ids[vote[2]]
+            if anon == nil then
+                count = count + 1
+                anon = 'anon:' .. count
+                ids[vote[2]] = anon
+            end
+            box.space.votes:delete({vote[1], vote[2], vote[3]})
+            box.space.votes:insert({vote[1], anon, vote[3]})
+        end
+        set_poll_fields(box.space.polls:get(poll_id), {anonymized = true})
+    end)
+end
+
+-- end_poll ends the active poll at ended_at in one transaction, it returns 'ok', 'not_found'
+-- or 'ended' if the poll is already ended, so only one of concurrent calls ends the poll
+function end_poll(poll_id, ended_at)
+    return box.atomic(function()
+        local poll = box.space.polls:get(poll_id)
+        if poll == nil then
+            return 'not_found'
+        end
+        if not poll.is_active then
+            return 'ended'
+        end
+        set_poll_fields(poll, {is_active = false, ended_at = ended_at})
+        return 'ok'
+    end)
+end
+
+-- remindable_users returns the users who have not opted out of reminders
+-- and were last reminded before reminded_before, in one call for all channel members
+function remindable_users(user_ids, reminded_before)
+    local users = setmetatable({}, {__serialize = "seq"})
+    for _, user_id in ipairs(user_ids) do
+        local reminder = box.space.reminders:get(user_id)
+        if box.space.reminder_optouts:get(user_id) == nil and
+                (reminder == nil or reminder.sent_at < reminded_before) then
+            table.insert(users, user_id)
+        end
+    end
+    return users
+end
+
+-- change_vote_count adds delta to the count of the choice in the votes json field of the poll
+local function change_vote_count(poll, choice_id, delta)
+    local votes = json.decode(poll[4])
+    votes[choice_id] = math.max((votes[choice_id] or 0) + delta, 0)
+    box.space.polls:update(poll[1], {{'=', 4, json.encode(votes)}})
+end
+
+-- cast_vote inserts the vote and increments the poll count in one transaction, so concurrent
+-- votes never overwrite each other's counts. It returns 'ok', 'not_found' or 'ended' for the poll,
+-- 'exists' if the user has voted for the choice and 'limit' if the user has chosen max_choices options
+function cast_vote(poll_id, user_id, choice_id, max_choices)
+    return box.atomic(function()
+        local poll = box.space.polls:get(poll_id)
+        if poll == nil then
+            return 'not_found'
+        end
+        if not poll[6] then
+            return 'ended'
+        end
+        if box.space.votes:get({poll_id, user_id, choice_id}) ~= nil then
+            return 'exists'
+        end
+        if box.space.votes.index.user_poll:count({poll_id, user_id}) >= max_choices then
+            return 'limit'
+        end
+        box.space.votes:insert({poll_id, user_id, choice_id})
+        change_vote_count(poll, choice_id, 1)
+        return 'ok'
+    end)
+end
+
+-- retract_vote deletes the vote and decrements the poll count in one transaction,
+-- it returns 'ok', 'not_found' or 'ended' for the poll and 'no_vote' if there is no such vote
+function retract_vote(poll_id, user_id, choice_id)
+    return box.atomic(function()
+        local poll = box.space.polls:get(poll_id)
+        if poll == nil then
+            return 'not_found'
+        end
+        if not poll[6] then
+            return 'ended'
+        end
+        if box.space.votes:delete({poll_id, user_id, choice_id}) == nil then
+            return 'no_vote'
+        end
+        change_vote_count(poll, choice_id, -1)
+        return 'ok'
+    end)
+end
+
+-- recount_votes sets the vote counts of the poll to the numbers of its votes in one transaction,
+-- so votes cast during the integrity repair are counted. It returns 'ok' or 'not_found'
+function recount_votes(poll_id)
+    return box.atomic(function()
+        local poll = box.space.polls:get(poll_id)
+        if poll == nil then
+            return 'not_found'
+        end
+        local votes = json.decode(poll[4])
+        for choice_id in pairs(votes) do
+            votes[choice_id] = 0
+        end
+        for _, vote in box.space.votes.index.poll:pairs({poll_id}) do
+            if votes[vote[3]] ~= nil then
+                votes[vote[3]] = votes[vote[3]] + 1
+            end
+        end
+        box.space.polls:update(poll_id, {{'=', 4, json.encode(votes)}})
+        return 'ok'
+    end)
+end
+
+-- rate_limit_take refills the token buckets by their rates per millisecond up to their bursts
+-- and takes one token from each of them if all have one, so a denied command uses up no bucket.
+-- full_at is when the bucket refills, rate_limit_sweep deletes it after that
+function rate_limit_take(keys, rates, bursts, now)
+    return box.atomic(function()
+        local tokens, allowed = {}, true
+        for i, key in ipairs(keys) do
+            tokens[i] = bursts[i]
+            local bucket = box.space.rate_limits:get(key)
+            if bucket ~= nil then
+                local elapsed = math.max(now - bucket.updated_at, 0)
+                tokens[i] = math.min(bursts[i], bucket.tokens + elapsed * rates[i])
+            end
+            allowed = allowed and tokens[i] >= 1
+        end
+        for i, key in ipairs(keys) do
+            if allowed then
+                tokens[i] = tokens[i] - 1
+            end
+            local full_at = now + math.ceil((bursts[i] - tokens[i]) / rates[i])
+            box.space.rate_limits:replace({key, tokens[i], now, full_at})
+        end
+        return allowed
+    end)
+end
+
+-- rate_limit_sweep deletes up to limit buckets which have refilled by now and returns their number,
+-- a missing bucket is the same as a full one
+function rate_limit_sweep(now, limit)
+    return box.atomic(function()
+        local keys = {}
+        for _, bucket in box.space.rate_limits.index.full:pairs({now}, {iterator = 'LE'}) do
+            if #keys >= limit then
+                break
+            end
+            table.insert(keys, bucket.key)
+        end
+        for _, key in ipairs(keys) do
+            box.space.rate_limits:delete({key})
+        end
+        return #keys
+    end)
+end
+
 local log = require('log').new(app_name)
 log.info('loaded')
 log.info("Tarantool is up and running!")